@@ -0,0 +1,164 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrUnknownCollector = errors.New("unknown collector")
+
+// Metric is a gauge value produced by collector.
+type Metric struct {
+	Name  string
+	Value float64
+}
+
+// Collector is a source of metrics polled by agent.
+type Collector interface {
+	Name() string
+	Collect(ctx context.Context) ([]Metric, error)
+}
+
+type collectorEntry struct {
+	collector Collector
+	metrics   []Metric
+	interval  time.Duration
+}
+
+// Registry polls collectors, each at its own interval, and keeps their last
+// metrics. Failure of one collector keeps its previous metrics and does not
+// affect others.
+type Registry struct {
+	entries []*collectorEntry
+	mu      sync.Mutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds collector polled every interval.
+func (r *Registry) Register(collector Collector, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("interval of collector %s must be positive, got %s", collector.Name(), interval)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, entry := range r.entries {
+		if entry.collector.Name() == collector.Name() {
+			return fmt.Errorf("collector %s is already registered", collector.Name())
+		}
+	}
+	r.entries = append(r.entries, &collectorEntry{collector: collector, interval: interval})
+	return nil
+}
+
+// Run polls collectors until ctx is done.
+func (r *Registry) Run(ctx context.Context) {
+	r.mu.Lock()
+	entries := append([]*collectorEntry(nil), r.entries...)
+	r.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, entry := range entries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(entry.interval)
+			defer ticker.Stop()
+			for {
+				r.collect(ctx, entry)
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Refresh polls all collectors once.
+func (r *Registry) Refresh(ctx context.Context) {
+	r.mu.Lock()
+	entries := append([]*collectorEntry(nil), r.entries...)
+	r.mu.Unlock()
+	for _, entry := range entries {
+		r.collect(ctx, entry)
+	}
+}
+
+func (r *Registry) collect(ctx context.Context, entry *collectorEntry) {
+	name := entry.collector.Name()
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("collector %s panicked: %v\n", name, p)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(ctx, entry.interval)
+	defer cancel()
+	metrics, err := entry.collector.Collect(ctx)
+	if err != nil {
+		log.Printf("error in collector %s: %v\n", name, err)
+	}
+	if len(metrics) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry.metrics = metrics
+}
+
+// Stats returns the last metrics of all collectors.
+func (r *Registry) Stats() map[string]float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := make(map[string]float64)
+	for _, entry := range r.entries {
+		for _, metric := range entry.metrics {
+			result[metric.Name] = metric.Value
+		}
+	}
+	return result
+}
+
+// parseCollectorIntervals parses list like "host=5,runtime=2" of intervals
+// in seconds.
+func parseCollectorIntervals(value string) (map[string]int, error) {
+	result := make(map[string]int)
+	if value == "" {
+		return result, nil
+	}
+	for _, item := range strings.Split(value, ",") {
+		name, seconds, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("collector interval must be like <name>=<seconds>, got %s", item)
+		}
+		interval, err := strconv.Atoi(seconds)
+		if err != nil {
+			return nil, fmt.Errorf("error in parse interval of collector %s: %w", name, err)
+		}
+		result[strings.TrimSpace(name)] = interval
+	}
+	return result, nil
+}
+
+func parseCollectorNames(value string) []string {
+	names := make([]string, 0)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,92 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCollector struct {
+	collect func(calls int64) ([]Metric, error)
+	name    string
+	calls   atomic.Int64
+}
+
+func (tc *testCollector) Name() string {
+	return tc.name
+}
+
+func (tc *testCollector) Collect(_ context.Context) ([]Metric, error) {
+	return tc.collect(tc.calls.Add(1))
+}
+
+func TestRegistryIsolatesCollectors(t *testing.T) {
+	good := &testCollector{name: "good", collect: func(calls int64) ([]Metric, error) {
+		return []Metric{{Name: "Good", Value: float64(calls)}}, nil
+	}}
+	flaky := &testCollector{name: "flaky", collect: func(calls int64) ([]Metric, error) {
+		if calls > 1 {
+			return nil, errors.New("source is gone")
+		}
+		return []Metric{{Name: "Flaky", Value: 1}}, nil
+	}}
+	panicking := &testCollector{name: "panicking", collect: func(int64) ([]Metric, error) {
+		panic("bug in collector")
+	}}
+
+	registry := NewRegistry()
+	require.NoError(t, registry.Register(good, time.Millisecond))
+	require.NoError(t, registry.Register(flaky, time.Millisecond))
+	require.NoError(t, registry.Register(panicking, time.Hour))
+	assert.Error(t, registry.Register(good, time.Second), "duplicate name")
+	assert.Error(t, registry.Register(&testCollector{name: "zero"}, 0))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		registry.Run(ctx)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool {
+		return good.calls.Load() > 5 && flaky.calls.Load() > 5
+	}, time.Second, time.Millisecond)
+	cancel()
+	<-done
+
+	stats := registry.Stats()
+	assert.Greater(t, stats["Good"], 5.0)
+	assert.InDelta(t, 1, stats["Flaky"], 0, "failed collector keeps its last metrics")
+	assert.Equal(t, int64(1), panicking.calls.Load(), "slow collector is polled at its own interval")
+}
+
+func TestNewRegistry(t *testing.T) {
+	conf := &config{
+		Collectors:         []string{"host", "runtime"},
+		CollectorIntervals: map[string]int{"host": 0},
+		PollInterval:       1,
+		HostPollInterval:   1,
+	}
+	runtimeStats := &runtimeCollector{}
+	registry, err := newRegistry(conf, runtimeStats)
+	require.NoError(t, err)
+	registry.Refresh(context.Background())
+	stats := registry.Stats()
+	assert.Contains(t, stats, "HeapAlloc")
+	assert.NotContains(t, stats, "TotalMemory", "host collector is disabled by zero interval")
+	assert.Equal(t, int64(1), runtimeStats.pollCount.Load())
+
+	conf.Collectors = []string{"gpu"}
+	_, err = newRegistry(conf, runtimeStats)
+	assert.ErrorIs(t, err, ErrUnknownCollector)
+
+	intervals, err := parseCollectorIntervals("host=5, runtime=2")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"host": 5, "runtime": 2}, intervals)
+	_, err = parseCollectorIntervals("host")
+	assert.Error(t, err)
+}
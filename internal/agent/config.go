@@ -1,16 +1,42 @@
 package agent
 
 import (
+	"crypto/rsa"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"log"
+	"net"
 	"os"
+	"slices"
 	"strconv"
+
+	"github.com/xChygyNx/metrical/internal/server/types"
 )
 
 type config struct {
-	HostAddr       HostPort
-	PollInterval   int
-	ReportInterval int
+	publicKey          *rsa.PublicKey
+	tlsConfig          *tls.Config
+	realIP             string
+	AgentID            string
+	OutboxDir          string
+	Protocol           string
+	GRPCAddress        string
+	Key                string
+	CryptoKey          string
+	TLSCA              string
+	TLSCert            string
+	TLSKey             string
+	CollectorIntervals map[string]int
+	Collectors         []string
+	HostAddr           HostPort
+	PollInterval       int
+	HostPollInterval   int
+	ReportInterval     int
+	RateLimit          int
+	BatchSize          int
+	OutboxMaxSize      int64
+	TLS                bool
 }
 
 func GetConfig() (*config, error) {
@@ -40,6 +66,89 @@ func GetConfig() (*config, error) {
 		config.ReportInterval = agentConfig.ReportInterval
 	}
 
+	hostPollInterval, ok := os.LookupEnv("HOST_POLL_INTERVAL")
+	if ok {
+		res, err := strconv.Atoi(hostPollInterval)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect value of environment variable HOST_POLL_INTERVAL: %w", err)
+		}
+		config.HostPollInterval = res
+	} else {
+		config.HostPollInterval = agentConfig.HostPollInterval
+	}
+
+	rateLimit, ok := os.LookupEnv("RATE_LIMIT")
+	if ok {
+		res, err := strconv.Atoi(rateLimit)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect value of environment variable RATE_LIMIT: %w", err)
+		}
+		config.RateLimit = res
+	} else {
+		config.RateLimit = agentConfig.RateLimit
+	}
+	if config.RateLimit < 1 {
+		return nil, fmt.Errorf("rate limit must be at least 1, got %d", config.RateLimit)
+	}
+
+	protocol, ok := os.LookupEnv("PROTOCOL")
+	if ok {
+		config.Protocol = protocol
+	} else {
+		config.Protocol = agentConfig.Protocol
+	}
+	if !slices.Contains(protocols, config.Protocol) {
+		return nil, fmt.Errorf("%w %q, must be one of %v", ErrUnknownProtocol, config.Protocol, protocols)
+	}
+
+	batchSize, ok := os.LookupEnv("BATCH_SIZE")
+	if ok {
+		res, err := strconv.Atoi(batchSize)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect value of environment variable BATCH_SIZE: %w", err)
+		}
+		config.BatchSize = res
+	} else {
+		config.BatchSize = agentConfig.BatchSize
+	}
+	if config.BatchSize < 0 {
+		return nil, fmt.Errorf("batch size must not be negative, got %d", config.BatchSize)
+	}
+
+	outboxDir, ok := os.LookupEnv("OUTBOX_DIR")
+	if ok {
+		config.OutboxDir = outboxDir
+	} else {
+		config.OutboxDir = agentConfig.OutboxDir
+	}
+
+	outboxMaxSize, ok := os.LookupEnv("OUTBOX_MAX_SIZE")
+	if ok {
+		res, err := strconv.ParseInt(outboxMaxSize, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect value of environment variable OUTBOX_MAX_SIZE: %w", err)
+		}
+		config.OutboxMaxSize = res
+	} else {
+		config.OutboxMaxSize = agentConfig.OutboxMaxSize
+	}
+
+	collectors, ok := os.LookupEnv("COLLECTORS")
+	if !ok {
+		collectors = agentConfig.Collectors
+	}
+	config.Collectors = parseCollectorNames(collectors)
+
+	collectorIntervals, ok := os.LookupEnv("COLLECTOR_INTERVALS")
+	if !ok {
+		collectorIntervals = agentConfig.CollectorIntervals
+	}
+	intervals, err := parseCollectorIntervals(collectorIntervals)
+	if err != nil {
+		return nil, err
+	}
+	config.CollectorIntervals = intervals
+
 	hostAddr, ok := os.LookupEnv("ADDRESS")
 	if ok {
 		err := config.HostAddr.Set(hostAddr)
@@ -50,5 +159,111 @@ func GetConfig() (*config, error) {
 		config.HostAddr = agentConfig.HostPort
 	}
 
+	agentID, ok := os.LookupEnv("AGENT_ID")
+	if ok {
+		config.AgentID = agentID
+	} else {
+		config.AgentID = agentConfig.AgentID
+	}
+	if config.AgentID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("error in get hostname for agent ID: %w", err)
+		}
+		config.AgentID = hostname
+	}
+
+	key, ok := os.LookupEnv("KEY")
+	if ok {
+		config.Key = key
+	} else {
+		config.Key = agentConfig.Key
+	}
+
+	cryptoKey, ok := os.LookupEnv("CRYPTO_KEY")
+	if ok {
+		config.CryptoKey = cryptoKey
+	} else {
+		config.CryptoKey = agentConfig.CryptoKey
+	}
+	if config.CryptoKey != "" {
+		publicKey, err := types.LoadPublicKey(config.CryptoKey)
+		if err != nil {
+			return nil, fmt.Errorf("error in load crypto key: %w", err)
+		}
+		config.publicKey = publicKey
+	}
+
+	err = config.setTLS(agentConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	grpcAddress, ok := os.LookupEnv("GRPC_ADDRESS")
+	if ok {
+		config.GRPCAddress = grpcAddress
+	} else {
+		config.GRPCAddress = agentConfig.GRPCAddress
+	}
+
+	serverAddr := config.HostAddr.String()
+	if config.GRPCAddress != "" {
+		serverAddr = config.GRPCAddress
+	}
+	realIP, err := outboundIP(serverAddr)
+	if err != nil {
+		log.Printf("error in detect outbound IP, %s header is not sent: %v\n", realIPHeader, err)
+	}
+	config.realIP = realIP
+
 	return config, nil
 }
+
+func (conf *config) setTLS(agentConfig *AgentConfig) error {
+	conf.TLS = agentConfig.TLS
+	useTLS, ok := os.LookupEnv("TLS")
+	if ok {
+		res, err := strconv.ParseBool(useTLS)
+		if err != nil {
+			return fmt.Errorf("incorrect value of environment variable TLS: %w", err)
+		}
+		conf.TLS = res
+	}
+
+	conf.TLSCA = agentConfig.TLSCA
+	if tlsCA, ok := os.LookupEnv("TLS_CA"); ok {
+		conf.TLSCA = tlsCA
+	}
+	conf.TLSCert = agentConfig.TLSCert
+	if tlsCert, ok := os.LookupEnv("TLS_CERT"); ok {
+		conf.TLSCert = tlsCert
+	}
+	conf.TLSKey = agentConfig.TLSKey
+	if tlsKey, ok := os.LookupEnv("TLS_KEY"); ok {
+		conf.TLSKey = tlsKey
+	}
+
+	tlsConfig, err := newTLSConfig(conf)
+	if err != nil {
+		return err
+	}
+	conf.tlsConfig = tlsConfig
+	return nil
+}
+
+// outboundIP returns local address which agent uses to reach server. Dial of
+// UDP socket only selects route and sends no packets.
+func outboundIP(serverAddr string) (string, error) {
+	conn, err := net.Dial("udp", serverAddr)
+	if err != nil {
+		return "", fmt.Errorf("error in dial server: %w", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	addr, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return "", fmt.Errorf("unexpected local address %s", conn.LocalAddr())
+	}
+	return addr.IP.String(), nil
+}
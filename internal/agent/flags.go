@@ -9,9 +9,25 @@ import (
 )
 
 type AgentConfig struct {
-	HostPort       HostPort
-	PollInterval   int
-	ReportInterval int
+	AgentID            string
+	OutboxDir          string
+	Protocol           string
+	Collectors         string
+	CollectorIntervals string
+	GRPCAddress        string
+	Key                string
+	CryptoKey          string
+	TLSCA              string
+	TLSCert            string
+	TLSKey             string
+	HostPort           HostPort
+	PollInterval       int
+	HostPollInterval   int
+	ReportInterval     int
+	RateLimit          int
+	BatchSize          int
+	OutboxMaxSize      int64
+	TLS                bool
 }
 
 type HostPort struct {
@@ -43,14 +59,56 @@ func parseFlag() *AgentConfig {
 	agentConfig := new(AgentConfig)
 	defaultPollInterval := 2
 	defaultReportInterval := 10
+	defaultOutboxMaxSize := int64(10 << 20)
+	defaultBatchSize := 100
 	pollInterval := flag.Int("p", defaultPollInterval, "Interval of collect metrics in seconds")
+	hostPollInterval := flag.Int("host-poll-interval", defaultPollInterval,
+		"Interval of collect host statistics from /proc in seconds, 0 disables it")
+	rateLimit := flag.Int("l", 1, "Maximal number of simultaneous requests to server")
+	protocol := flag.String("protocol", ProtocolBatch,
+		"Send protocol: single (JSON per metric), batch (JSON arrays to /updates/) or legacy (metric in URL path)")
+	batchSize := flag.Int("batch-size", defaultBatchSize,
+		"Maximal number of metrics in one batch request, 0 sends whole report in one request")
+	outboxDir := flag.String("outbox-dir", "", "Directory where reports are kept while server is unavailable")
+	outboxMaxSize := flag.Int64("outbox-max-size", defaultOutboxMaxSize,
+		"Size limit of outbox in bytes, the oldest reports are merged above it")
+	collectors := flag.String("collectors", "runtime,host", "Comma separated list of enabled collectors")
+	collectorIntervals := flag.String("collector-intervals", "",
+		"Poll intervals of collectors in seconds like host=5,runtime=2, override -p and -host-poll-interval")
 	reportInterval := flag.Int("r", defaultReportInterval, "Interval of send metrics on server in seconds")
 
 	hostPort := new(HostPort)
 	flag.Var(hostPort, "a", "Net address host:port")
 
+	agentID := flag.String("n", "", "Stable ID of agent sent to server, hostname by default")
+
+	key := flag.String("k", "", "Key for HMAC-SHA256 signing of request and response bodies")
+
+	cryptoKey := flag.String("crypto-key", "", "Path to PEM file with RSA public key of server for encrypting requests")
+	grpcAddress := flag.String("grpc-address", "", "Net address host:port of gRPC server, sends by HTTP if empty")
+	useTLS := flag.Bool("tls", false, "Send metrics over HTTPS, implied by any of TLS files")
+	tlsCA := flag.String("tls-ca", "", "Path to CA bundle for verifying server certificate")
+	tlsCert := flag.String("tls-cert", "", "Path to PEM file with client certificate")
+	tlsKey := flag.String("tls-key", "", "Path to PEM file with private key of client certificate")
+
 	flag.Parse()
+	agentConfig.AgentID = *agentID
+	agentConfig.Key = *key
+	agentConfig.CryptoKey = *cryptoKey
+	agentConfig.GRPCAddress = *grpcAddress
+	agentConfig.TLS = *useTLS
+	agentConfig.TLSCA = *tlsCA
+	agentConfig.TLSCert = *tlsCert
+	agentConfig.TLSKey = *tlsKey
 	agentConfig.PollInterval = *pollInterval
+	agentConfig.HostPollInterval = *hostPollInterval
+	agentConfig.RateLimit = *rateLimit
+	agentConfig.Protocol = *protocol
+	agentConfig.BatchSize = *batchSize
+	agentConfig.OutboxDir = *outboxDir
+	agentConfig.OutboxMaxSize = *outboxMaxSize
+	agentConfig.Collectors = *collectors
+	agentConfig.CollectorIntervals = *collectorIntervals
 	agentConfig.ReportInterval = *reportInterval
 
 	if hostPort.Host == "" && hostPort.Port == 0 {
@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+
+	pb "github.com/xChygyNx/metrical/internal/proto"
+	"github.com/xChygyNx/metrical/internal/server/types"
+)
+
+// grpcSender sends metrics to gRPC server of metrics storage.
+type grpcSender struct {
+	conn   *grpc.ClientConn
+	client pb.MetricsClient
+	conf   *config
+}
+
+func newGRPCSender(conf *config) (*grpcSender, error) {
+	creds := insecure.NewCredentials()
+	if conf.tlsConfig != nil {
+		creds = credentials.NewTLS(conf.tlsConfig)
+	}
+	conn, err := grpc.NewClient(conf.GRPCAddress, grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultCallOptions(grpc.UseCompressor(gzip.Name)))
+	if err != nil {
+		return nil, fmt.Errorf("error in create gRPC client: %w", err)
+	}
+	return &grpcSender{conn: conn, client: pb.NewMetricsClient(conn), conf: conf}, nil
+}
+
+// Send sends metrics in one UpdateMetrics call with idempotency key.
+func (gs *grpcSender) Send(ctx context.Context, metrics []types.Metrics, key string) error {
+	req := &pb.UpdateMetricsRequest{Metrics: make([]*pb.Metric, 0, len(metrics))}
+	for _, metric := range metrics {
+		if metric.MType == counterType {
+			req.Metrics = append(req.Metrics, &pb.Metric{Id: metric.ID, Type: pb.Metric_COUNTER, Delta: *metric.Delta})
+			continue
+		}
+		req.Metrics = append(req.Metrics, &pb.Metric{Id: metric.ID, Type: pb.Metric_GAUGE, Value: *metric.Value})
+	}
+
+	md := metadata.MD{}
+	if gs.conf.AgentID != "" {
+		md.Set(pb.AgentIDKey, gs.conf.AgentID)
+	}
+	if gs.conf.realIP != "" {
+		md.Set(pb.RealIPKey, gs.conf.realIP)
+	}
+	if key != "" {
+		md.Set(pb.IdempotencyKey, key)
+	}
+	if gs.conf.Key != "" {
+		sign, err := pb.SignMessage(req, gs.conf.Key)
+		if err != nil {
+			return err
+		}
+		md.Set(pb.HashKey, sign)
+	}
+
+	var header metadata.MD
+	resp, err := gs.client.UpdateMetrics(metadata.NewOutgoingContext(ctx, md), req, grpc.Header(&header))
+	if err != nil {
+		return fmt.Errorf("error in gRPC UpdateMetrics: %w", err)
+	}
+	if gs.conf.Key != "" {
+		signs := header.Get(pb.HashKey)
+		if len(signs) == 0 || !pb.CheckMessageSign(resp, gs.conf.Key, signs[0]) {
+			return ErrBadResponseSign
+		}
+	}
+	return nil
+}
+
+func (gs *grpcSender) Close() error {
+	err := gs.conn.Close()
+	if err != nil {
+		return fmt.Errorf("error in close gRPC connection: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	procPath   = "/proc"
+	sectorSize = 512
+	kibibyte   = 1024
+)
+
+var errBadProcFormat = errors.New("unexpected format of /proc file")
+
+// cpuTimes are cumulative busy and total jiffies of one core.
+type cpuTimes struct {
+	busy  uint64
+	total uint64
+}
+
+// hostCollector polls host statistics from /proc.
+type hostCollector struct {
+	prevCPU map[string]cpuTimes
+	root    string
+	mu      sync.Mutex
+}
+
+func newHostCollector(root string) *hostCollector {
+	return &hostCollector{
+		prevCPU: make(map[string]cpuTimes),
+		root:    root,
+	}
+}
+
+func (hc *hostCollector) Name() string {
+	return "host"
+}
+
+// Collect reads all /proc files. Statistics of readable files are returned
+// even if other files fail.
+func (hc *hostCollector) Collect(_ context.Context) ([]Metric, error) {
+	stats := make(map[string]float64)
+	parsers := map[string]func(io.Reader, map[string]float64) error{
+		"meminfo":   parseMemInfo,
+		"loadavg":   parseLoadAvg,
+		"diskstats": parseDiskStats,
+		"net/dev":   parseNetDev,
+	}
+	var errs []error
+	for name, parse := range parsers {
+		errs = append(errs, hc.readProc(name, func(r io.Reader) error {
+			return parse(r, stats)
+		}))
+	}
+
+	hc.mu.Lock()
+	errs = append(errs, hc.readProc("stat", func(r io.Reader) error {
+		return parseCPUStat(r, hc.prevCPU, stats)
+	}))
+	hc.mu.Unlock()
+
+	metrics := make([]Metric, 0, len(stats))
+	for name, value := range stats {
+		metrics = append(metrics, Metric{Name: name, Value: value})
+	}
+	return metrics, errors.Join(errs...)
+}
+
+func (hc *hostCollector) readProc(name string, parse func(io.Reader) error) (err error) {
+	file, err := os.Open(filepath.Join(hc.root, name))
+	if err != nil {
+		return fmt.Errorf("error in open %s: %w", name, err)
+	}
+	defer func() {
+		closeErr := file.Close()
+		if closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+	err = parse(file)
+	if err != nil {
+		return fmt.Errorf("error in parse %s: %w", name, err)
+	}
+	return nil
+}
+
+// parseMemInfo reads TotalMemory and FreeMemory in bytes.
+func parseMemInfo(r io.Reader, stats map[string]float64) error {
+	names := map[string]string{"MemTotal:": "TotalMemory", "MemFree:": "FreeMemory"}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		name, ok := names[fields[0]]
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %s", errBadProcFormat, scanner.Text())
+		}
+		stats[name] = float64(value * kibibyte)
+	}
+	return scanner.Err()
+}
+
+// parseLoadAvg reads LoadAverage1, LoadAverage5 and LoadAverage15.
+func parseLoadAvg(r io.Reader, stats map[string]float64) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("error in read: %w", err)
+	}
+	fields := strings.Fields(string(data))
+	names := []string{"LoadAverage1", "LoadAverage5", "LoadAverage15"}
+	if len(fields) < len(names) {
+		return fmt.Errorf("%w: %s", errBadProcFormat, data)
+	}
+	for i, name := range names {
+		value, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return fmt.Errorf("%w: %s", errBadProcFormat, data)
+		}
+		stats[name] = value
+	}
+	return nil
+}
+
+// parseCPUStat reads CPUutilization<N> of every core in percents since
+// previous poll, or since boot on the first one.
+func parseCPUStat(r io.Reader, prev map[string]cpuTimes, stats map[string]float64) error {
+	const idleField, iowaitField, timeFields = 4, 5, 9
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < timeFields || !strings.HasPrefix(fields[0], "cpu") || fields[0] == "cpu" {
+			continue
+		}
+		var times cpuTimes
+		for i := 1; i < timeFields; i++ {
+			value, err := strconv.ParseUint(fields[i], 10, 64)
+			if err != nil {
+				return fmt.Errorf("%w: %s", errBadProcFormat, scanner.Text())
+			}
+			times.total += value
+			if i != idleField && i != iowaitField {
+				times.busy += value
+			}
+		}
+		core := strings.TrimPrefix(fields[0], "cpu")
+		last := prev[core]
+		prev[core] = times
+		if times.total <= last.total {
+			continue
+		}
+		stats["CPUutilization"+core] = 100 * float64(times.busy-last.busy) / float64(times.total-last.total)
+	}
+	return scanner.Err()
+}
+
+// parseDiskStats reads DiskReadBytes_<dev> and DiskWrittenBytes_<dev> of
+// block devices except loop and ram ones.
+func parseDiskStats(r io.Reader, stats map[string]float64) error {
+	const nameField, readField, writtenField = 2, 5, 9
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) <= writtenField {
+			continue
+		}
+		device := fields[nameField]
+		if strings.HasPrefix(device, "loop") || strings.HasPrefix(device, "ram") {
+			continue
+		}
+		read, err := strconv.ParseUint(fields[readField], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %s", errBadProcFormat, scanner.Text())
+		}
+		written, err := strconv.ParseUint(fields[writtenField], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %s", errBadProcFormat, scanner.Text())
+		}
+		stats["DiskReadBytes_"+device] = float64(read * sectorSize)
+		stats["DiskWrittenBytes_"+device] = float64(written * sectorSize)
+	}
+	return scanner.Err()
+}
+
+// parseNetDev reads NetworkReceivedBytes_<iface> and NetworkSentBytes_<iface>
+// of interfaces except loopback.
+func parseNetDev(r io.Reader, stats map[string]float64) error {
+	const receivedField, sentField = 0, 8
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		iface, counters, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		iface = strings.TrimSpace(iface)
+		fields := strings.Fields(counters)
+		if iface == "lo" || len(fields) <= sentField {
+			continue
+		}
+		received, err := strconv.ParseUint(fields[receivedField], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %s", errBadProcFormat, scanner.Text())
+		}
+		sent, err := strconv.ParseUint(fields[sentField], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %s", errBadProcFormat, scanner.Text())
+		}
+		stats["NetworkReceivedBytes_"+iface] = float64(received)
+		stats["NetworkSentBytes_"+iface] = float64(sent)
+	}
+	return scanner.Err()
+}
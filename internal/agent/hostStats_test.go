@@ -0,0 +1,107 @@
+package agent
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testMemInfo = `MemTotal:        2048 kB
+MemFree:         1024 kB
+MemAvailable:    1536 kB
+`
+	testLoadAvg   = "0.50 0.25 0.10 1/100 4242\n"
+	testDiskStats = `   7       0 loop0 10 0 80 0 0 0 0 0 0 0 0
+   8       0 sda 100 0 200 10 50 0 400 20 0 30 30
+`
+	testNetDev = `Inter-| Receive | Transmit
+ face |bytes packets errs drop fifo frame compressed multicast|bytes packets errs drop fifo colls carrier compressed
+    lo: 500 5 0 0 0 0 0 0 500 5 0 0 0 0 0 0
+  eth0: 1000 10 0 0 0 0 0 0 2000 20 0 0 0 0 0 0
+`
+)
+
+func TestParseProcFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		parse func(io.Reader, map[string]float64) error
+		input string
+		want  map[string]float64
+	}{
+		{
+			name:  "meminfo",
+			parse: parseMemInfo,
+			input: testMemInfo,
+			want:  map[string]float64{"TotalMemory": 2048 * 1024, "FreeMemory": 1024 * 1024},
+		},
+		{
+			name:  "loadavg",
+			parse: parseLoadAvg,
+			input: testLoadAvg,
+			want:  map[string]float64{"LoadAverage1": 0.5, "LoadAverage5": 0.25, "LoadAverage15": 0.1},
+		},
+		{
+			name:  "diskstats",
+			parse: parseDiskStats,
+			input: testDiskStats,
+			want:  map[string]float64{"DiskReadBytes_sda": 200 * 512, "DiskWrittenBytes_sda": 400 * 512},
+		},
+		{
+			name:  "net/dev",
+			parse: parseNetDev,
+			input: testNetDev,
+			want:  map[string]float64{"NetworkReceivedBytes_eth0": 1000, "NetworkSentBytes_eth0": 2000},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stats := make(map[string]float64)
+			require.NoError(t, test.parse(strings.NewReader(test.input), stats))
+			assert.Equal(t, test.want, stats)
+		})
+	}
+	assert.ErrorIs(t, parseLoadAvg(strings.NewReader("0.5"), map[string]float64{}), errBadProcFormat)
+}
+
+func TestParseCPUStat(t *testing.T) {
+	prev := make(map[string]cpuTimes)
+	stats := make(map[string]float64)
+	first := "cpu  20 0 20 60 0 0 0 0 0 0\ncpu0 10 0 10 80 0 0 0 0 0 0\ncpu1 10 0 10 0 0 0 0 0 0 0\n"
+	require.NoError(t, parseCPUStat(strings.NewReader(first), prev, stats))
+	assert.Equal(t, map[string]float64{"CPUutilization0": 20, "CPUutilization1": 100}, stats)
+
+	second := "cpu0 35 0 35 130 0 0 0 0 0 0\ncpu1 10 0 10 100 0 0 0 0 0 0\n"
+	require.NoError(t, parseCPUStat(strings.NewReader(second), prev, stats))
+	assert.Equal(t, map[string]float64{"CPUutilization0": 50, "CPUutilization1": 0}, stats)
+}
+
+func TestHostCollector(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"meminfo":   testMemInfo,
+		"loadavg":   testLoadAvg,
+		"diskstats": testDiskStats,
+		"stat":      "cpu0 1 0 1 2 0 0 0 0 0 0\n",
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(root, name), []byte(content), 0o600))
+	}
+
+	host := newHostCollector(root)
+	metrics, err := host.Collect(context.Background())
+	assert.Error(t, err, "net/dev is missing")
+	stats := make(map[string]float64)
+	for _, metric := range metrics {
+		stats[metric.Name] = metric.Value
+	}
+	assert.InDelta(t, 2048*1024, stats["TotalMemory"], 0)
+	assert.InDelta(t, 50, stats["CPUutilization0"], 0)
+	assert.InDelta(t, 0.5, stats["LoadAverage1"], 0)
+}
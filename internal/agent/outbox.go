@@ -0,0 +1,211 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const outboxExt = ".json"
+
+// outboxRecord is a report stored on disk.
+type outboxRecord struct {
+	Gauges    map[string]float64 `json:"gauges"`
+	ID        string             `json:"id,omitempty"`
+	Batches   []reportBatch      `json:"batches,omitempty"`
+	PollCount int64              `json:"poll_count"`
+}
+
+type outboxEntry struct {
+	name string
+	seq  uint64
+	size int64
+}
+
+// outbox is an on-disk queue of reports which server did not accept. Every
+// report is a file named by its sequence number. When total size exceeds
+// maxSize, the oldest reports are merged, so counter deltas are never lost.
+type outbox struct {
+	dir     string
+	maxSize int64
+	nextSeq uint64
+	mu      sync.Mutex
+}
+
+func openOutbox(dir string, maxSize int64) (*outbox, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("error in create outbox directory: %w", err)
+	}
+	ob := &outbox{dir: dir, maxSize: maxSize}
+	entries, err := ob.list()
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		ob.nextSeq = entries[len(entries)-1].seq + 1
+	}
+	return ob, nil
+}
+
+// mergeReports folds older report into newer one: gauges of newer report
+// win, counter deltas are summed. Merged report is new content, so it gets
+// new ID and its requests get new keys.
+func mergeReports(older, newer reportData) reportData {
+	stats := make(map[string]float64, len(older.stats)+len(newer.stats))
+	for name, value := range older.stats {
+		stats[name] = value
+	}
+	for name, value := range newer.stats {
+		stats[name] = value
+	}
+	return reportData{stats: stats, id: newReportID(), pollCount: older.pollCount + newer.pollCount}
+}
+
+func (ob *outbox) list() ([]outboxEntry, error) {
+	dirEntries, err := os.ReadDir(ob.dir)
+	if err != nil {
+		return nil, fmt.Errorf("error in read outbox directory: %w", err)
+	}
+	entries := make([]outboxEntry, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, outboxExt), 10, 64)
+		if err != nil || !strings.HasSuffix(name, outboxExt) {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("error in stat outbox file: %w", err)
+		}
+		entries = append(entries, outboxEntry{name: name, seq: seq, size: info.Size()})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].seq < entries[j].seq
+	})
+	return entries, nil
+}
+
+func (ob *outbox) read(name string) (reportData, error) {
+	content, err := os.ReadFile(filepath.Join(ob.dir, name))
+	if err != nil {
+		return reportData{}, fmt.Errorf("error in read outbox file: %w", err)
+	}
+	var record outboxRecord
+	err = json.Unmarshal(content, &record)
+	if err != nil {
+		return reportData{}, fmt.Errorf("error in parse outbox file %s: %w", name, err)
+	}
+	return reportData{stats: record.Gauges, id: record.ID, batches: record.Batches, pollCount: record.PollCount}, nil
+}
+
+// write replaces file atomically, so crash never leaves half written report.
+func (ob *outbox) write(name string, data reportData) error {
+	content, err := json.Marshal(outboxRecord{
+		Gauges:    data.stats,
+		ID:        data.id,
+		Batches:   data.batches,
+		PollCount: data.pollCount,
+	})
+	if err != nil {
+		return fmt.Errorf("error in serialize report for outbox: %w", err)
+	}
+	tmpPath := filepath.Join(ob.dir, name+".tmp")
+	err = os.WriteFile(tmpPath, content, 0o600)
+	if err != nil {
+		return fmt.Errorf("error in write outbox file: %w", err)
+	}
+	err = os.Rename(tmpPath, filepath.Join(ob.dir, name))
+	if err != nil {
+		return fmt.Errorf("error in rename outbox file: %w", err)
+	}
+	return nil
+}
+
+// Push appends report to the end of queue.
+func (ob *outbox) Push(data reportData) error {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	err := ob.write(strconv.FormatUint(ob.nextSeq, 10)+outboxExt, data)
+	if err != nil {
+		return err
+	}
+	ob.nextSeq++
+	return ob.compact()
+}
+
+// compact merges the oldest reports while queue is larger than maxSize.
+func (ob *outbox) compact() error {
+	entries, err := ob.list()
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, entry := range entries {
+		size += entry.size
+	}
+	for len(entries) > 1 && size > ob.maxSize {
+		oldest, next := entries[0], entries[1]
+		older, err := ob.read(oldest.name)
+		if err != nil {
+			return err
+		}
+		newer, err := ob.read(next.name)
+		if err != nil {
+			return err
+		}
+		err = ob.write(next.name, mergeReports(older, newer))
+		if err != nil {
+			return err
+		}
+		err = os.Remove(filepath.Join(ob.dir, oldest.name))
+		if err != nil {
+			return fmt.Errorf("error in remove outbox file: %w", err)
+		}
+		info, err := os.Stat(filepath.Join(ob.dir, next.name))
+		if err != nil {
+			return fmt.Errorf("error in stat outbox file: %w", err)
+		}
+		size += info.Size() - oldest.size - next.size
+		entries[1].size = info.Size()
+		entries = entries[1:]
+	}
+	return nil
+}
+
+// Replay sends stored reports oldest first and removes sent ones. It stops
+// at the first failure, so order of reports is kept. Report which is sent
+// partially is replaced by its unsent part returned by send.
+func (ob *outbox) Replay(send func(reportData) (reportData, error)) error {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	entries, err := ob.list()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		data, err := ob.read(entry.name)
+		if err != nil {
+			return err
+		}
+		unsent, err := send(data)
+		if err != nil {
+			writeErr := ob.write(entry.name, unsent)
+			if writeErr != nil {
+				return errors.Join(err, writeErr)
+			}
+			return err
+		}
+		err = os.Remove(filepath.Join(ob.dir, entry.name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("error in remove outbox file: %w", err)
+		}
+	}
+	return nil
+}
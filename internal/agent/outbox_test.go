@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutboxReplaysInOrder(t *testing.T) {
+	dir := t.TempDir()
+	ob, err := openOutbox(dir, 1<<20)
+	require.NoError(t, err)
+	for i := range 3 {
+		require.NoError(t, ob.Push(reportData{stats: map[string]float64{"Alloc": float64(i)}, pollCount: 1}))
+	}
+
+	errDown := errors.New("server is down")
+	var sent []float64
+	err = ob.Replay(func(data reportData) (reportData, error) {
+		if len(sent) == 1 {
+			return data, errDown
+		}
+		sent = append(sent, data.stats["Alloc"])
+		return reportData{}, nil
+	})
+	assert.ErrorIs(t, err, errDown)
+	assert.Equal(t, []float64{0}, sent)
+
+	reopened, err := openOutbox(dir, 1<<20)
+	require.NoError(t, err)
+	require.NoError(t, reopened.Push(reportData{stats: map[string]float64{"Alloc": 3}, pollCount: 1}))
+	require.NoError(t, reopened.Replay(func(data reportData) (reportData, error) {
+		sent = append(sent, data.stats["Alloc"])
+		return reportData{}, nil
+	}))
+	assert.Equal(t, []float64{0, 1, 2, 3}, sent)
+
+	entries, err := reopened.list()
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestOutboxKeepsUnsentPart(t *testing.T) {
+	ob, err := openOutbox(t.TempDir(), 1<<20)
+	require.NoError(t, err)
+	require.NoError(t, ob.Push(reportData{stats: map[string]float64{"Alloc": 1, "Sys": 2}, id: "report-1", pollCount: 5}))
+
+	errDown := errors.New("server is down")
+	err = ob.Replay(func(reportData) (reportData, error) {
+		return reportData{stats: map[string]float64{"Sys": 2}, id: "report-1"}, errDown
+	})
+	assert.ErrorIs(t, err, errDown)
+
+	var replayed []reportData
+	require.NoError(t, ob.Replay(func(data reportData) (reportData, error) {
+		replayed = append(replayed, data)
+		return reportData{}, nil
+	}))
+	require.Len(t, replayed, 1)
+	assert.Equal(t, map[string]float64{"Sys": 2}, replayed[0].stats)
+	assert.Zero(t, replayed[0].pollCount, "delivered counter is not sent again")
+	assert.Equal(t, "report-1", replayed[0].id, "report ID is kept on disk")
+}
+
+func TestOutboxMergesOverSizeLimit(t *testing.T) {
+	ob, err := openOutbox(t.TempDir(), 1)
+	require.NoError(t, err)
+	for i := range 5 {
+		require.NoError(t, ob.Push(reportData{
+			stats:     map[string]float64{"Alloc": float64(i), "Only" + string(rune('A'+i)): 1},
+			pollCount: int64(i + 1),
+		}))
+	}
+
+	var replayed []reportData
+	require.NoError(t, ob.Replay(func(data reportData) (reportData, error) {
+		replayed = append(replayed, data)
+		return reportData{}, nil
+	}))
+	require.Len(t, replayed, 1)
+	assert.Equal(t, int64(15), replayed[0].pollCount, "counter deltas are summed")
+	assert.InDelta(t, 4, replayed[0].stats["Alloc"], 0, "the newest gauge wins")
+	assert.Len(t, replayed[0].stats, 6)
+}
@@ -0,0 +1,68 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// sendJob is one outbound request to server.
+type sendJob struct {
+	ctx    context.Context
+	send   func(ctx context.Context) error
+	result chan<- sendResult
+	index  int
+}
+
+type sendResult struct {
+	err   error
+	index int
+}
+
+// senderPool limits number of simultaneous requests to server by number of
+// its workers.
+type senderPool struct {
+	jobs chan sendJob
+	wg   sync.WaitGroup
+}
+
+func newSenderPool(workers int) *senderPool {
+	pool := &senderPool{jobs: make(chan sendJob)}
+	for range workers {
+		pool.wg.Add(1)
+		go func() {
+			defer pool.wg.Done()
+			for job := range pool.jobs {
+				job.result <- sendResult{err: job.send(job.ctx), index: job.index}
+			}
+		}()
+	}
+	return pool
+}
+
+// Do runs sends on workers and waits for all of them.
+func (sp *senderPool) Do(ctx context.Context, sends []func(ctx context.Context) error) error {
+	return errors.Join(sp.Run(ctx, sends)...)
+}
+
+// Run is like Do, but returns result of every send in order of sends.
+func (sp *senderPool) Run(ctx context.Context, sends []func(ctx context.Context) error) []error {
+	results := make(chan sendResult, len(sends))
+	go func() {
+		for i, send := range sends {
+			sp.jobs <- sendJob{ctx: ctx, send: send, result: results, index: i}
+		}
+	}()
+	errs := make([]error, len(sends))
+	for range sends {
+		result := <-results
+		errs[result.index] = result.err
+	}
+	return errs
+}
+
+// Close stops workers after they finish queued jobs.
+func (sp *senderPool) Close() {
+	close(sp.jobs)
+	sp.wg.Wait()
+}
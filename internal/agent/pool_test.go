@@ -0,0 +1,45 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSenderPoolLimitsConcurrency(t *testing.T) {
+	const workers = 3
+	pool := newSenderPool(workers)
+	defer pool.Close()
+
+	var running, maxRunning, done atomic.Int64
+	errSend := errors.New("server is down")
+	sends := make([]func(context.Context) error, 0, 20)
+	for i := range 20 {
+		sends = append(sends, func(context.Context) error {
+			current := running.Add(1)
+			defer running.Add(-1)
+			for {
+				prev := maxRunning.Load()
+				if current <= prev || maxRunning.CompareAndSwap(prev, current) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			done.Add(1)
+			if i == 7 {
+				return errSend
+			}
+			return nil
+		})
+	}
+
+	err := pool.Do(context.Background(), sends)
+	assert.ErrorIs(t, err, errSend)
+	assert.Equal(t, int64(20), done.Load())
+	assert.LessOrEqual(t, maxRunning.Load(), int64(workers))
+	assert.NoError(t, pool.Do(context.Background(), sends[:3]))
+}
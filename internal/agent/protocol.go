@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+
+	"github.com/xChygyNx/metrical/internal/server/types"
+)
+
+var ErrUnknownProtocol = errors.New("unknown send protocol")
+
+const (
+	// ProtocolSingle sends every metric as JSON to /update.
+	ProtocolSingle = "single"
+	// ProtocolBatch sends metrics as JSON arrays to /updates/.
+	ProtocolBatch = "batch"
+	// ProtocolLegacy sends every metric by /update/{type}/{name}/{value}.
+	ProtocolLegacy = "legacy"
+)
+
+var protocols = []string{ProtocolSingle, ProtocolBatch, ProtocolLegacy}
+
+// reportMetrics converts report to metrics in stable order. Poll counter is
+// included exactly once, so it is counted by server once per report.
+func reportMetrics(data reportData) []types.Metrics {
+	names := make([]string, 0, len(data.stats))
+	for name := range data.stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	metrics := make([]types.Metrics, 0, len(names)+1)
+	for _, name := range names {
+		value := data.stats[name]
+		metrics = append(metrics, types.Metrics{ID: name, MType: gaugeType, Value: &value})
+	}
+	pollCount := data.pollCount
+	metrics = append(metrics, types.Metrics{ID: pollCountMetric, MType: counterType, Delta: &pollCount})
+	return metrics
+}
+
+// splitMetrics groups metrics by requests: one metric per request for single
+// and legacy protocols, at most batchSize metrics per request for batch one.
+// Zero batchSize puts all metrics in one request.
+func splitMetrics(metrics []types.Metrics, protocol string, batchSize int) [][]types.Metrics {
+	if protocol != ProtocolBatch {
+		batchSize = 1
+	}
+	if batchSize == 0 || batchSize >= len(metrics) {
+		return [][]types.Metrics{metrics}
+	}
+	requests := make([][]types.Metrics, 0, (len(metrics)+batchSize-1)/batchSize)
+	for start := 0; start < len(metrics); start += batchSize {
+		end := min(start+batchSize, len(metrics))
+		requests = append(requests, metrics[start:end])
+	}
+	return requests
+}
+
+// reportBatch is metrics of one request with its idempotency key.
+type reportBatch struct {
+	Key     string          `json:"key,omitempty"`
+	Metrics []types.Metrics `json:"metrics"`
+}
+
+// reportBatches splits report into requests. Requests of partly sent report
+// are reused, so they are resent with the same content and keys. They are
+// split again only if protocol sending one metric per request can't send
+// them.
+func reportBatches(data reportData, protocol string, batchSize int) []reportBatch {
+	reusable := len(data.batches) > 0
+	for _, batch := range data.batches {
+		if protocol != ProtocolBatch && len(batch.Metrics) != 1 {
+			reusable = false
+		}
+	}
+	if reusable {
+		return data.batches
+	}
+	requests := splitMetrics(reportMetrics(data), protocol, batchSize)
+	batches := make([]reportBatch, 0, len(requests))
+	for i, metrics := range requests {
+		batches = append(batches, reportBatch{Key: requestKey(data.id, i), Metrics: metrics})
+	}
+	return batches
+}
+
+// unsentReport collects failed requests and their metrics, errs are results
+// of requests in the same order.
+func unsentReport(batches []reportBatch, errs []error) reportData {
+	unsent := reportData{stats: make(map[string]float64)}
+	for i, batch := range batches {
+		if errs[i] == nil {
+			continue
+		}
+		unsent.batches = append(unsent.batches, batch)
+		for _, metric := range batch.Metrics {
+			if metric.MType == counterType {
+				unsent.pollCount += *metric.Delta
+				continue
+			}
+			unsent.stats[metric.ID] = *metric.Value
+		}
+	}
+	return unsent
+}
+
+// requestKey is idempotency key of index-th request of report. Key is kept
+// with unsent request, so resent request has the same key and server doesn't
+// apply it twice. Reports without ID are sent without key.
+func requestKey(reportID string, index int) string {
+	if reportID == "" {
+		return ""
+	}
+	return reportID + "-" + strconv.Itoa(index)
+}
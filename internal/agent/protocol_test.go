@@ -0,0 +1,260 @@
+package agent
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xChygyNx/metrical/internal/server/types"
+)
+
+// recordingServer remembers metrics of accepted requests and rejects
+// requests which contain rejected metric. Invalid metric is rejected alone
+// in partial batch. Server without partial mode replies to batch with saved
+// metrics.
+type recordingServer struct {
+	rejected  string
+	invalid   string
+	noPartial bool
+	mu        sync.Mutex
+	paths     []string
+	keys      []string
+	modes     []string
+	gauges    map[string]float64
+	counter   int64
+}
+
+func (rs *recordingServer) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	var metrics []types.Metrics
+	if req.Header.Get(contentEncoding) == contentEncodingValue {
+		reader, err := gzip.NewReader(req.Body)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body, err := io.ReadAll(reader)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if strings.HasPrefix(req.URL.Path, updatesPath) {
+			err = json.Unmarshal(body, &metrics)
+		} else {
+			metrics = make([]types.Metrics, 1)
+			err = json.Unmarshal(body, &metrics[0])
+		}
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		// Legacy path /update/{type}/{name}/{value}.
+		parts := strings.Split(req.URL.Path, "/")
+		value, err := strconv.ParseFloat(parts[4], 64)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		delta := int64(value)
+		metrics = []types.Metrics{{ID: parts[3], MType: parts[2], Value: &value, Delta: &delta}}
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.keys = append(rs.keys, req.Header.Get(types.IdempotencyKeyHeader))
+	for _, metric := range metrics {
+		if metric.ID == rs.rejected {
+			http.Error(res, "rejected", http.StatusBadRequest)
+			return
+		}
+	}
+	rs.paths = append(rs.paths, req.URL.Path)
+	var result types.BatchResult
+	for i, metric := range metrics {
+		if metric.ID == rs.invalid {
+			result.Results = append(result.Results, types.BatchItemResult{ID: metric.ID, Index: i,
+				Error: &types.ErrorResponse{Code: http.StatusBadRequest, Message: "invalid", Field: "id"}})
+			continue
+		}
+		result.Results = append(result.Results, types.BatchItemResult{ID: metric.ID, Index: i, Accepted: true})
+		if metric.MType == counterType {
+			rs.counter += *metric.Delta
+			continue
+		}
+		rs.gauges[metric.ID] = *metric.Value
+	}
+	if strings.HasPrefix(req.URL.Path, updatesPath) {
+		rs.modes = append(rs.modes, req.URL.Query().Get("mode"))
+		var response any = result
+		if rs.noPartial {
+			response = metrics
+		}
+		body, err := json.Marshal(response)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, _ = res.Write(body)
+	}
+}
+
+func TestReportProtocols(t *testing.T) {
+	data := reportData{
+		stats:     map[string]float64{"Alloc": 1, "Frees": 2, "HeapSys": 3, "Sys": 4, "TotalAlloc": 5},
+		pollCount: 7,
+	}
+	tests := []struct {
+		name      string
+		protocol  string
+		batchSize int
+		rejected  string
+		invalid   string
+		noPartial bool
+		saved     int
+		requests  int
+		path      string
+		unsent    reportData
+	}{
+		{
+			name:     "Single protocol sends request per metric",
+			protocol: ProtocolSingle,
+			requests: 6,
+			path:     updatePath,
+		},
+		{
+			name:     "Legacy protocol sends metric in path",
+			protocol: ProtocolLegacy,
+			requests: 6,
+			path:     "/update/counter/PollCount/7",
+		},
+		{
+			name:      "Batch protocol splits report by batch size",
+			protocol:  ProtocolBatch,
+			batchSize: 4,
+			requests:  2,
+			path:      updatesPath,
+		},
+		{
+			name:     "Zero batch size sends report in one request",
+			protocol: ProtocolBatch,
+			requests: 1,
+			path:     updatesPath,
+		},
+		{
+			name:      "Only metrics of failed batch are unsent",
+			protocol:  ProtocolBatch,
+			batchSize: 2,
+			rejected:  "Frees",
+			requests:  2,
+			path:      updatesPath,
+			unsent:    reportData{stats: map[string]float64{"Alloc": 1, "Frees": 2}},
+		},
+		{
+			name:     "Metric rejected in partial batch is not resent",
+			protocol: ProtocolBatch,
+			invalid:  "Sys",
+			requests: 1,
+			path:     updatesPath,
+			saved:    4,
+		},
+		{
+			name:      "Batch accepted by server without partial mode is not resent",
+			protocol:  ProtocolBatch,
+			noPartial: true,
+			requests:  1,
+			path:      updatesPath,
+		},
+		{
+			name:      "Counter of failed batch is unsent",
+			protocol:  ProtocolBatch,
+			batchSize: 2,
+			rejected:  "TotalAlloc",
+			requests:  2,
+			path:      updatesPath,
+			unsent:    reportData{stats: map[string]float64{"TotalAlloc": 5}, pollCount: 7},
+		},
+	}
+	pool := newSenderPool(2)
+	defer pool.Close()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := &recordingServer{
+				rejected:  test.rejected,
+				invalid:   test.invalid,
+				noPartial: test.noPartial,
+				gauges:    make(map[string]float64),
+			}
+			ts := httptest.NewServer(server)
+			defer ts.Close()
+			conf := &config{Protocol: test.protocol, BatchSize: test.batchSize}
+			require.NoError(t, conf.HostAddr.Set(strings.TrimPrefix(ts.URL, "http://")))
+
+			unsent, err := report(context.Background(), pool, getRetryClient(conf), nil, data, conf)
+			if test.rejected != "" {
+				assert.ErrorIs(t, err, ErrBadResponseStatus)
+				assert.Equal(t, test.unsent.stats, unsent.stats)
+				assert.Equal(t, test.unsent.pollCount, unsent.pollCount)
+			} else {
+				require.NoError(t, err)
+				assert.Empty(t, unsent.stats)
+				assert.Equal(t, data.pollCount, server.counter, "counter is sent exactly once")
+				saved := len(data.stats)
+				if test.saved != 0 {
+					saved = test.saved
+				}
+				assert.Len(t, server.gauges, saved)
+			}
+			assert.Len(t, server.paths, test.requests)
+			assert.Contains(t, server.paths, test.path)
+			for _, mode := range server.modes {
+				assert.Equal(t, "partial", mode)
+			}
+		})
+	}
+}
+
+func TestReportIdempotencyKeys(t *testing.T) {
+	data := reportData{
+		stats:     map[string]float64{"Alloc": 1, "Frees": 2, "HeapSys": 3},
+		id:        "report-1",
+		pollCount: 7,
+	}
+	pool := newSenderPool(1)
+	defer pool.Close()
+	server := &recordingServer{rejected: "Frees", gauges: make(map[string]float64)}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	conf := &config{Protocol: ProtocolBatch, BatchSize: 2}
+	require.NoError(t, conf.HostAddr.Set(strings.TrimPrefix(ts.URL, "http://")))
+
+	unsent, err := report(context.Background(), pool, getRetryClient(conf), nil, data, conf)
+	require.ErrorIs(t, err, ErrBadResponseStatus)
+	assert.Equal(t, data.id, unsent.id, "unsent part keeps report ID")
+	require.Len(t, server.keys, 2)
+	assert.NotEqual(t, server.keys[0], server.keys[1], "requests of report have different keys")
+	require.Len(t, unsent.batches, 1)
+
+	// Unsent part goes through outbox, so keys of its requests survive restart.
+	ob, err := openOutbox(t.TempDir(), 1<<20)
+	require.NoError(t, err)
+	require.NoError(t, ob.Push(unsent))
+	server.rejected = ""
+	require.NoError(t, ob.Replay(func(stored reportData) (reportData, error) {
+		return report(context.Background(), pool, getRetryClient(conf), nil, stored, conf)
+	}))
+	require.Len(t, server.keys, 3, "unsent request is resent as is")
+	assert.Equal(t, server.keys[0], server.keys[2], "resent request has the same key")
+	assert.Equal(t, int64(7), server.counter, "counter is sent once")
+
+	assert.Empty(t, requestKey("", 0), "report without ID is sent without key")
+}
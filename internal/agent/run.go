@@ -1,20 +1,40 @@
 package agent
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"log"
-	"math/rand"
+	mathrand "math/rand"
+	"net/http"
+	"os/signal"
 	"runtime"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/sethgrid/pester"
 )
 
+var errReportQueued = errors.New("report is kept in outbox")
+
 const (
-	countRetries = 3
+	countRetries       = 3
+	reportIDSize       = 16
+	finalReportTimeout = 5 * time.Second
 )
 
-func getRetryClient() *pester.Client {
+// getRetryClient returns HTTP client which retries failed requests. It is
+// built once, so connections and TLS sessions are reused between reports.
+func getRetryClient(conf *config) *pester.Client {
 	client := pester.New()
+	if conf.tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = conf.tlsConfig
+		client = pester.NewExtendedClient(&http.Client{Transport: transport})
+	}
 	client.MaxRetries = countRetries
 	client.Backoff = pester.ExponentialBackoff
 	return client
@@ -50,55 +70,268 @@ func prepareStatsForSend(stats *runtime.MemStats) map[string]float64 {
 	result["StackSys"] = float64(stats.StackSys)
 	result["Sys"] = float64(stats.Sys)
 	result["TotalAlloc"] = float64(stats.TotalAlloc)
-	result["RandomValue"] = rand.Float64()
+	result["RandomValue"] = mathrand.Float64()
 
 	return result
 }
 
-func Run() error {
-	var pollCount int
+// runtimeCollector polls runtime.MemStats of agent and counts its polls.
+type runtimeCollector struct {
+	pollCount atomic.Int64
+}
+
+func (rc *runtimeCollector) Name() string {
+	return "runtime"
+}
+
+func (rc *runtimeCollector) Collect(_ context.Context) ([]Metric, error) {
 	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	rc.pollCount.Add(1)
+
+	stats := prepareStatsForSend(&memStats)
+	metrics := make([]Metric, 0, len(stats))
+	for name, value := range stats {
+		metrics = append(metrics, Metric{Name: name, Value: value})
+	}
+	return metrics, nil
+}
+
+// newRegistry registers collectors enabled in config. Interval 0 disables
+// collector.
+func newRegistry(conf *config, runtimeStats *runtimeCollector) (*Registry, error) {
+	available := map[string]struct {
+		collector Collector
+		interval  int
+	}{
+		runtimeStats.Name(): {collector: runtimeStats, interval: conf.PollInterval},
+		"host":              {collector: newHostCollector(procPath), interval: conf.HostPollInterval},
+	}
+	for name := range conf.CollectorIntervals {
+		if _, ok := available[name]; !ok {
+			return nil, fmt.Errorf("%w %s", ErrUnknownCollector, name)
+		}
+	}
+
+	registry := NewRegistry()
+	for _, name := range conf.Collectors {
+		item, ok := available[name]
+		if !ok {
+			return nil, fmt.Errorf("%w %s", ErrUnknownCollector, name)
+		}
+		interval := item.interval
+		if custom, ok := conf.CollectorIntervals[name]; ok {
+			interval = custom
+		}
+		if interval == 0 {
+			continue
+		}
+		err := registry.Register(item.collector, time.Duration(interval)*time.Second)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return registry, nil
+}
+
+// reportData is a snapshot of metrics waiting for sending. Its ID makes
+// idempotency keys of its requests. Partly sent report keeps its unsent
+// requests in batches, stats and pollCount hold their metrics.
+type reportData struct {
+	stats     map[string]float64
+	id        string
+	batches   []reportBatch
+	pollCount int64
+}
+
+func newReportID() string {
+	id := make([]byte, reportIDSize)
+	_, err := rand.Read(id)
+	if err != nil {
+		log.Printf("error in generate report ID, report is sent without idempotency keys: %v\n", err)
+		return ""
+	}
+	return hex.EncodeToString(id)
+}
+
+// report sends collected metrics to server by configured protocol, by gRPC
+// batches if sender is set. Every request is a separate job of pool. Metrics
+// of failed requests are returned, so they are resent without duplicating
+// delivered ones.
+func report(ctx context.Context, pool *senderPool, client *pester.Client, sender *grpcSender, data reportData,
+	conf *config) (reportData, error) {
+	protocol := conf.Protocol
+	if sender != nil {
+		protocol = ProtocolBatch
+	}
+	batches := reportBatches(data, protocol, conf.BatchSize)
+
+	sends := make([]func(context.Context) error, 0, len(batches))
+	for _, batch := range batches {
+		sends = append(sends, func(ctx context.Context) error {
+			switch {
+			case sender != nil:
+				return sender.Send(ctx, batch.Metrics, batch.Key)
+			case protocol == ProtocolSingle:
+				return SendMetric(ctx, client, batch.Metrics[0], batch.Key, conf)
+			case protocol == ProtocolLegacy:
+				return SendMetricPath(ctx, client, batch.Metrics[0], batch.Key, conf)
+			default:
+				return BatchSend(ctx, client, batch.Metrics, batch.Key, conf)
+			}
+		})
+	}
+	errs := pool.Run(ctx, sends)
+	err := errors.Join(errs...)
+	if err != nil {
+		unsent := unsentReport(batches, errs)
+		unsent.id = data.id
+		return unsent, err
+	}
+	return reportData{}, nil
+}
+
+// deliverer sends reports. Unsent part of report is kept in outbox if it is
+// set, or its polls are returned to runtime collector to be sent with the next
+// one.
+type deliverer struct {
+	pool         *senderPool
+	client       *pester.Client
+	sender       *grpcSender
+	outbox       *outbox
+	runtimeStats *runtimeCollector
+	conf         *config
+}
+
+// deliver replays outbox and then sends data, so server gets reports in
+// order of their collection.
+func (d *deliverer) deliver(ctx context.Context, data reportData) error {
+	if d.outbox != nil {
+		err := d.outbox.Replay(func(stored reportData) (reportData, error) {
+			return report(ctx, d.pool, d.client, d.sender, stored, d.conf)
+		})
+		if err != nil {
+			return d.keep(data, fmt.Errorf("error in replay outbox: %w", err))
+		}
+	}
+	unsent, err := report(ctx, d.pool, d.client, d.sender, data, d.conf)
+	if err != nil {
+		return d.keep(unsent, err)
+	}
+	return nil
+}
+
+func (d *deliverer) keep(data reportData, cause error) error {
+	if d.outbox == nil {
+		d.runtimeStats.pollCount.Add(data.pollCount)
+		return cause
+	}
+	err := d.outbox.Push(data)
+	if err != nil {
+		d.runtimeStats.pollCount.Add(data.pollCount)
+		return errors.Join(cause, fmt.Errorf("error in push report to outbox: %w", err))
+	}
+	return fmt.Errorf("%w: %w", errReportQueued, cause)
+}
 
+// dispatch delivers reports one by one.
+func dispatch(ctx context.Context, reports <-chan reportData, d *deliverer) {
+	for data := range reports {
+		err := d.deliver(ctx, data)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func Run() error {
 	config, err := GetConfig()
 	if err != nil {
 		return err
 	}
-	pollTicker := time.NewTicker(time.Duration(config.PollInterval) * time.Second)
+
+	runtimeStats := &runtimeCollector{}
+	registry, err := newRegistry(config, runtimeStats)
+	if err != nil {
+		return err
+	}
+
+	var sender *grpcSender
+	if config.GRPCAddress != "" {
+		sender, err = newGRPCSender(config)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			closeErr := sender.Close()
+			if closeErr != nil {
+				log.Println(closeErr)
+			}
+		}()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	defer stop()
+	go registry.Run(ctx)
+
+	pool := newSenderPool(config.RateLimit)
+	defer pool.Close()
+	d := &deliverer{
+		pool:         pool,
+		client:       getRetryClient(config),
+		sender:       sender,
+		runtimeStats: runtimeStats,
+		conf:         config,
+	}
+	if config.OutboxDir != "" {
+		d.outbox, err = openOutbox(config.OutboxDir, config.OutboxMaxSize)
+		if err != nil {
+			return err
+		}
+	}
+	// Sending never blocks polling and reporting: while previous report is
+	// being sent, the newest one waits in reports.
+	reports := make(chan reportData, 1)
+	dispatched := make(chan struct{})
+	go func() {
+		defer close(dispatched)
+		dispatch(ctx, reports, d)
+	}()
+
 	reportTicker := time.NewTicker(time.Duration(config.ReportInterval) * time.Second)
+	defer reportTicker.Stop()
 	for {
 		select {
-		case <-pollTicker.C:
-			runtime.ReadMemStats(&memStats)
-			pollCount++
 		case <-reportTicker.C:
-			sendInfo := prepareStatsForSend(&memStats)
-			client := getRetryClient()
-
-			err = SendGauge(client, sendInfo, config.HostAddr)
-			if err != nil {
-				log.Printf("error in send gauge: %v\n", err)
-				continue
+			data := reportData{stats: registry.Stats(), id: newReportID(), pollCount: runtimeStats.pollCount.Swap(0)}
+			select {
+			case reports <- data:
+			default:
+				// Waiting report is outdated, replace its gauges but keep its polls.
+				select {
+				case waiting := <-reports:
+					data = mergeReports(waiting, data)
+				default:
+				}
+				reports <- data
 			}
-
-			err = SendCounter(client, pollCount, config.HostAddr)
-			if err != nil {
-				log.Printf("error in send counter: %v\n", err)
-				continue
+		case <-ctx.Done():
+			close(reports)
+			<-dispatched
+			// Send metrics collected since the last report, so they are not lost.
+			finalCtx, cancel := context.WithTimeout(context.Background(), finalReportTimeout)
+			registry.Refresh(finalCtx)
+			data := reportData{stats: registry.Stats(), id: newReportID(), pollCount: runtimeStats.pollCount.Swap(0)}
+			err = d.deliver(finalCtx, data)
+			cancel()
+			if errors.Is(err, errReportQueued) {
+				log.Println(err)
+				return nil
 			}
-
-			err = BatchSendGauge(client, sendInfo, config.HostAddr)
 			if err != nil {
-				log.Printf("error in batch send gauge: %v\n", err)
-				continue
+				return fmt.Errorf("error in final report: %w", err)
 			}
-
-			err = BatchSendCounter(client, pollCount, config.HostAddr)
-			if err != nil {
-				log.Printf("error in batch send counter: %v\n", err)
-				continue
-			}
-
-			pollCount = 0
+			return nil
 		}
 	}
 }
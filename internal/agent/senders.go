@@ -2,221 +2,211 @@ package agent
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os"
+	"net/url"
+	"strconv"
 
 	"github.com/sethgrid/pester"
 	"github.com/xChygyNx/metrical/internal/server/types"
 )
 
+var (
+	ErrBadResponseSign   = errors.New("missing or invalid signature of server response")
+	ErrBadResponseStatus = errors.New("server rejected request")
+)
+
 const (
 	contentType          = "Content-Type"
 	contentTypeValue     = "application/json"
+	textContentTypeValue = "text/plain"
 	contentEncoding      = "Content-Encoding"
 	contentEncodingValue = "gzip"
-	countGaugeMetrics    = 28
 	responseStatusMsg    = "response Status: "
 	responseHeadersMsg   = "response Headers: "
 	responseBodyMsg      = "response Body: "
+	updatePath           = "/update"
+	updatesPath          = "/updates/"
+	realIPHeader         = "X-Real-IP"
+	gaugeType            = "gauge"
+	counterType          = "counter"
+	pollCountMetric      = "PollCount"
 )
 
-func SendGauge(client *pester.Client, sendInfo map[string]float64, hostAddr HostPort) (err error) {
-	iterationLogic := func(attr string, value float64) (err error) {
-		urlString := "http://" + hostAddr.String() + "/update"
-
-		sendJSON := types.Metrics{
-			ID:    attr,
-			MType: "gauge",
-			Value: &value,
-		}
-		jsonString, err := json.Marshal(sendJSON)
-		if err != nil {
-			return fmt.Errorf("error in serialize json for send gauge metric: %w", err)
-		}
-
-		compressJSON, err := compress(jsonString)
-		if err != nil {
-			return fmt.Errorf("error in compress gauge metrics: %w", err)
-		}
-
-		req, err := http.NewRequest(http.MethodPost, urlString, bytes.NewBuffer(compressJSON))
+// newMetricRequest prepares POST request with compressed JSON body to server.
+// If key is set, uncompressed body is signed in HashSHA256 header. If public
+// key of server is set, compressed body is encrypted by it.
+func newMetricRequest(ctx context.Context, conf *config, path string, body []byte) (*http.Request, error) {
+	compressBody, err := compress(body)
+	if err != nil {
+		return nil, fmt.Errorf("error in compress metrics: %w", err)
+	}
+	var encryption string
+	if conf.publicKey != nil {
+		compressBody, encryption, err = types.Encrypt(conf.publicKey, compressBody)
 		if err != nil {
-			return fmt.Errorf("failed to create http Request: %w", err)
-		}
-		req.Header.Set(contentType, contentTypeValue)
-		req.Header.Set(contentEncoding, contentEncodingValue)
-		resp, err := client.Do(req)
-		if err != nil && !errors.Is(err, io.EOF) {
-			return fmt.Errorf("failed to send http Request by http Client: %w", err)
+			return nil, fmt.Errorf("error in encrypt metrics: %w", err)
 		}
-		defer func() {
-			err = resp.Body.Close()
-		}()
+	}
+	urlString := conf.scheme() + conf.HostAddr.String() + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, urlString, bytes.NewBuffer(compressBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create http Request: %w", err)
+	}
+	req.Header.Set(contentType, contentTypeValue)
+	req.Header.Set(contentEncoding, contentEncodingValue)
+	if conf.AgentID != "" {
+		req.Header.Set(types.AgentIDHeader, conf.AgentID)
+	}
+	if conf.realIP != "" {
+		req.Header.Set(realIPHeader, conf.realIP)
+	}
+	if encryption != "" {
+		req.Header.Set(types.EncryptionHeader, encryption)
+	}
+	if conf.Key != "" {
+		req.Header.Set(types.HashHeader, types.Sign(body, conf.Key))
+	}
+	return req, nil
+}
 
-		log.Println(responseStatusMsg, resp.Status)
-		log.Println(responseHeadersMsg, resp.Header)
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return fmt.Errorf("error in read response body: %w", err)
-		}
-		log.Println(responseBodyMsg, string(body))
-		err = resp.Body.Close()
-		if err != nil {
-			_, err = io.Copy(os.Stdout, bytes.NewReader([]byte(err.Error())))
-			if err != nil {
-				return fmt.Errorf("error in copy text of error in stdout: %w", err)
-			}
-		}
-		return
+// checkResponseSign validates signature of response body when key is set.
+// Server doesn't sign empty bodies.
+func checkResponseSign(conf *config, resp *http.Response, body []byte) error {
+	if conf.Key == "" || len(body) == 0 {
+		return nil
 	}
-	for attr, value := range sendInfo {
-		err = iterationLogic(attr, value)
-		if err != nil {
-			return fmt.Errorf("error in SendGauge: %w", err)
-		}
+	if !types.CheckSign(body, conf.Key, resp.Header.Get(types.HashHeader)) {
+		return ErrBadResponseSign
 	}
-	return
+	return nil
 }
 
-func SendCounter(client *pester.Client, pollCount int, hostAddr HostPort) (err error) {
-	counterPath := "http://" + hostAddr.String() + "/update"
-	pollCount64 := int64(pollCount)
-	sendJSON := types.Metrics{
-		ID:    "PollCount",
-		MType: "counter",
-		Delta: &pollCount64,
-	}
-	jsonString, err := json.Marshal(sendJSON)
-	if err != nil {
-		return fmt.Errorf("error in serialize json for counter metric: %w", err)
+// newPathRequest prepares POST request of legacy protocol, where metric is
+// passed in URL path and body is empty. If key is set, method and path are
+// signed in HashSHA256 header.
+func newPathRequest(ctx context.Context, conf *config, metric types.Metrics) (*http.Request, error) {
+	var value string
+	if metric.MType == gaugeType {
+		value = strconv.FormatFloat(*metric.Value, 'f', -1, 64)
+	} else {
+		value = strconv.FormatInt(*metric.Delta, 10)
 	}
-	compressJSON, err := compress(jsonString)
+	urlString := conf.scheme() + conf.HostAddr.String() + updatePath + "/" + metric.MType + "/" +
+		url.PathEscape(metric.ID) + "/" + value
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, urlString, http.NoBody)
 	if err != nil {
-		return fmt.Errorf("error in compress counter metrics: %w", err)
+		return nil, fmt.Errorf("failed to create http Request: %w", err)
 	}
-	req, err := http.NewRequest(http.MethodPost, counterPath, bytes.NewBuffer(compressJSON))
-	if err != nil {
-		return
+	req.Header.Set(contentType, textContentTypeValue)
+	if conf.AgentID != "" {
+		req.Header.Set(types.AgentIDHeader, conf.AgentID)
+	}
+	if conf.realIP != "" {
+		req.Header.Set(realIPHeader, conf.realIP)
+	}
+	if conf.Key != "" {
+		signed := types.RequestSignData(http.MethodPost, req.URL.RequestURI())
+		req.Header.Set(types.HashHeader, types.Sign(signed, conf.Key))
+	}
+	return req, nil
+}
+
+// doRequest sends request with idempotency key, checks status and signature
+// of response and returns its body.
+func doRequest(client *pester.Client, conf *config, req *http.Request, key string) (body []byte, err error) {
+	if key != "" {
+		req.Header.Set(types.IdempotencyKeyHeader, key)
 	}
-	req.Header.Set(contentType, contentTypeValue)
-	req.Header.Set(contentEncoding, contentEncodingValue)
 	resp, err := client.Do(req)
 	if err != nil {
-		return
+		return nil, fmt.Errorf("failed to send http Request by http Client: %w", err)
 	}
 	defer func() {
-		err = resp.Body.Close()
+		closeErr := resp.Body.Close()
+		if closeErr != nil && err == nil {
+			err = closeErr
+		}
 	}()
 
 	log.Println(responseStatusMsg, resp.Status)
 	log.Println(responseHeadersMsg, resp.Header)
-	body, err := io.ReadAll(resp.Body)
+	body, err = io.ReadAll(resp.Body)
 	if err != nil {
-		return
+		return nil, fmt.Errorf("error in read response body: %w", err)
 	}
 	log.Println(responseBodyMsg, string(body))
-	return
-}
-
-func BatchSendGauge(client *pester.Client, sendInfo map[string]float64, hostAddr HostPort) (err error) {
-	sendData := make([]types.Metrics, 0, countGaugeMetrics)
-	urlString := "http://" + hostAddr.String() + "/updates/"
-
-	for attr, value := range sendInfo {
-		metricInfo := types.Metrics{
-			ID:    attr,
-			MType: "gauge",
-			Value: &value,
-		}
-		sendData = append(sendData, metricInfo)
-	}
-
-	jsonString, err := json.Marshal(sendData)
-	if err != nil {
-		return fmt.Errorf("error in serialize json for send gauge metric: %w", err)
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return nil, fmt.Errorf("%w: %s", ErrBadResponseStatus, resp.Status)
 	}
+	return body, checkResponseSign(conf, resp, body)
+}
 
-	compressJSON, err := compress(jsonString)
+// SendMetric sends one metric as JSON to /update.
+func SendMetric(ctx context.Context, client *pester.Client, metric types.Metrics, key string, conf *config) error {
+	jsonString, err := json.Marshal(metric)
 	if err != nil {
-		return fmt.Errorf("error in compress gauge metrics: %w", err)
+		return fmt.Errorf("error in serialize json for send metric: %w", err)
 	}
-
-	req, err := http.NewRequest(http.MethodPost, urlString, bytes.NewBuffer(compressJSON))
+	req, err := newMetricRequest(ctx, conf, updatePath, jsonString)
 	if err != nil {
-		return fmt.Errorf("failed to create http Request: %w", err)
+		return err
 	}
-	req.Header.Set(contentType, contentTypeValue)
-	req.Header.Set(contentEncoding, contentEncodingValue)
-	resp, err := client.Do(req)
+	_, err = doRequest(client, conf, req, key)
 	if err != nil {
-		return fmt.Errorf("failed to send http Request by http Client: %w", err)
+		return fmt.Errorf("error in send metric %s: %w", metric.ID, err)
 	}
-	defer func() {
-		err = resp.Body.Close()
-	}()
+	return nil
+}
 
-	log.Println(responseStatusMsg, resp.Status)
-	log.Println(responseHeadersMsg, resp.Header)
-	body, err := io.ReadAll(resp.Body)
+// SendMetricPath sends one metric by legacy /update/{type}/{name}/{value}.
+func SendMetricPath(ctx context.Context, client *pester.Client, metric types.Metrics, key string,
+	conf *config) error {
+	req, err := newPathRequest(ctx, conf, metric)
 	if err != nil {
-		return fmt.Errorf("error in read response body: %w", err)
+		return err
 	}
-	log.Println(responseBodyMsg, string(body))
-	err = resp.Body.Close()
+	_, err = doRequest(client, conf, req, key)
 	if err != nil {
-		_, err = io.Copy(os.Stdout, bytes.NewReader([]byte(err.Error())))
-		if err != nil {
-			return fmt.Errorf("error in copy text of error in stdout: %w", err)
-		}
+		return fmt.Errorf("error in send metric %s: %w", metric.ID, err)
 	}
-
-	return
+	return nil
 }
 
-func BatchSendCounter(client *pester.Client, pollCount int, hostAddr HostPort) (err error) {
-	counterPath := "http://" + hostAddr.String() + "/updates/"
-	pollCount64 := int64(pollCount)
-	sendData := make([]types.Metrics, 0, 1)
-	metricInfo := types.Metrics{
-		ID:    "PollCount",
-		MType: "counter",
-		Delta: &pollCount64,
-	}
-
-	sendData = append(sendData, metricInfo)
-	jsonString, err := json.Marshal(sendData)
+// BatchSend sends metrics as JSON array to /updates/ in partial mode. Metrics
+// rejected by server are invalid, so they are logged and not resent. Any
+// successful response is treated as accepted batch.
+func BatchSend(ctx context.Context, client *pester.Client, metrics []types.Metrics, key string,
+	conf *config) error {
+	jsonString, err := json.Marshal(metrics)
 	if err != nil {
-		return fmt.Errorf("error in serialize json for counter metric: %w", err)
+		return fmt.Errorf("error in serialize json for send batch of metrics: %w", err)
 	}
-	compressJSON, err := compress(jsonString)
+	req, err := newMetricRequest(ctx, conf, updatesPath+"?mode=partial", jsonString)
 	if err != nil {
-		return fmt.Errorf("error in compress counter metrics: %w", err)
+		return err
 	}
-	req, err := http.NewRequest(http.MethodPost, counterPath, bytes.NewBuffer(compressJSON))
+	body, err := doRequest(client, conf, req, key)
 	if err != nil {
-		return
+		return fmt.Errorf("error in send batch of %d metrics: %w", len(metrics), err)
 	}
-	req.Header.Set(contentType, contentTypeValue)
-	req.Header.Set(contentEncoding, contentEncodingValue)
-	resp, err := client.Do(req)
+	var result types.BatchResult
+	err = json.Unmarshal(body, &result)
 	if err != nil {
-		return
+		// Server without partial mode replies with saved metrics, batch is
+		// accepted anyway, so it must not be resent.
+		log.Printf("batch is sent, but its result isn't recognized: %v\n", err)
+		return nil
 	}
-	defer func() {
-		err = resp.Body.Close()
-	}()
-
-	log.Println(responseStatusMsg, resp.Status)
-	log.Println(responseHeadersMsg, resp.Header)
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return
+	for _, item := range result.Results {
+		if !item.Accepted && item.Error != nil {
+			log.Printf("metric %s is rejected by server: %s %s\n", item.ID, item.Error.Field, item.Error.Message)
+		}
 	}
-	log.Println(responseBodyMsg, string(body))
-	return
+	return nil
 }
@@ -0,0 +1,49 @@
+package agent
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const (
+	httpScheme  = "http://"
+	httpsScheme = "https://"
+)
+
+// newTLSConfig returns TLS config for connections to server, or nil when
+// agent uses plain HTTP. Without CA bundle system roots are trusted.
+func newTLSConfig(conf *config) (*tls.Config, error) {
+	if !conf.TLS && conf.TLSCA == "" && conf.TLSCert == "" && conf.TLSKey == "" {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if conf.TLSCA != "" {
+		data, err := os.ReadFile(conf.TLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("error in read CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.New("CA bundle has no PEM encoded certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if conf.TLSCert != "" || conf.TLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(conf.TLSCert, conf.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("error in load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func (conf *config) scheme() string {
+	if conf.tlsConfig != nil {
+		return httpsScheme
+	}
+	return httpScheme
+}
@@ -0,0 +1,36 @@
+package proto
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/xChygyNx/metrical/internal/server/types"
+)
+
+// Metadata keys of gRPC calls, counterparts of HTTP headers.
+const (
+	AgentIDKey     = "x-agent-id"
+	RealIPKey      = "x-real-ip"
+	HashKey        = "hashsha256"
+	IdempotencyKey = "idempotency-key"
+	ReplayedKey    = "idempotent-replayed"
+)
+
+// SignMessage returns HMAC-SHA256 of deterministic encoding of msg.
+func SignMessage(msg proto.Message, key string) (string, error) {
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return "", fmt.Errorf("error in marshal message for sign: %w", err)
+	}
+	return types.Sign(data, key), nil
+}
+
+// CheckMessageSign reports whether sign is a valid signature of msg.
+func CheckMessageSign(msg proto.Message, key, sign string) bool {
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return false
+	}
+	return types.CheckSign(data, key, sign)
+}
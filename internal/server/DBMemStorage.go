@@ -8,38 +8,783 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/xChygyNx/metrical/internal/server/types"
 )
 
-func writeMetricStorageDB(db *sql.DB, storage *types.MemStorage) (err error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+const (
+	// Upserts also record new value of metric in samples for range queries.
+	sqlUpsertGauge = `
+		WITH upserted AS (
+			INSERT INTO gauges(metric_name, labels, value) VALUES ($1, $2, $3)
+			ON CONFLICT (metric_name, labels) DO UPDATE SET value = EXCLUDED.value, updated_at = now()
+			RETURNING metric_name, labels, value
+		)
+		INSERT INTO samples(metric_type, metric_name, labels, value)
+		SELECT 'gauge', metric_name, labels, value FROM upserted
+		RETURNING value`
+	// Total is returned from bigint counters, because samples keep it as
+	// double precision and round totals above 2^53.
+	sqlUpsertCounter = `
+		WITH upserted AS (
+			INSERT INTO counters(metric_name, labels, value) VALUES ($1, $2, $3)
+			ON CONFLICT (metric_name, labels) DO UPDATE SET value = counters.value + EXCLUDED.value, updated_at = now()
+			RETURNING metric_name, labels, value
+		), sampled AS (
+			INSERT INTO samples(metric_type, metric_name, labels, value)
+			SELECT 'counter', metric_name, labels, value FROM upserted
+		)
+		SELECT value FROM upserted`
+	sqlInsertGaugeSamples = `
+		INSERT INTO samples(metric_type, metric_name, labels, value)
+		SELECT 'gauge', metric_name, labels, value FROM gauges
+		WHERE (metric_name, labels) IN (SELECT * FROM unnest($1::text[], $2::text[]))`
+	sqlInsertCounterSamples = `
+		INSERT INTO samples(metric_type, metric_name, labels, value)
+		SELECT 'counter', metric_name, labels, value FROM counters
+		WHERE (metric_name, labels) IN (SELECT * FROM unnest($1::text[], $2::text[]))`
+	// Only the latest historySize samples of series are kept.
+	sqlPruneSamples = `
+		DELETE FROM samples WHERE id IN (
+			SELECT id FROM (
+				SELECT id, row_number() OVER (PARTITION BY metric_name, labels ORDER BY id DESC) AS n
+				FROM samples
+				WHERE metric_type = $1 AND (metric_name, labels) IN (SELECT * FROM unnest($2::text[], $3::text[]))
+			) ranked
+			WHERE n > $4)`
+	// Range query returns at most $6 latest samples.
+	sqlSelectSamples = `
+		SELECT created_at, value FROM (
+			SELECT created_at, value FROM samples
+			WHERE metric_type = $1 AND metric_name = $2 AND labels = $3 AND created_at BETWEEN $4 AND $5
+			ORDER BY created_at DESC LIMIT $6
+		) latest
+		ORDER BY created_at`
+	// Counts are added only to histogram with the same bounds, otherwise no
+	// row is returned.
+	sqlUpsertHistogram = `
+		INSERT INTO histograms(metric_name, labels, bounds, counts, sum) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (metric_name, labels) DO UPDATE SET
+			counts = ARRAY(
+				SELECT t.saved + t.added FROM unnest(histograms.counts, EXCLUDED.counts)
+				WITH ORDINALITY AS t(saved, added, i) ORDER BY t.i),
+			sum = histograms.sum + EXCLUDED.sum,
+			updated_at = now()
+		WHERE histograms.bounds = EXCLUDED.bounds
+		RETURNING counts, sum`
+	sqlUpsertSummary = `
+		INSERT INTO summaries(metric_name, labels, quantiles, quantile_values, sum, count)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (metric_name, labels) DO UPDATE SET quantiles = EXCLUDED.quantiles,
+			quantile_values = EXCLUDED.quantile_values, sum = EXCLUDED.sum, count = EXCLUDED.count, updated_at = now()`
+	sqlSelectGauge     = `SELECT value FROM gauges WHERE metric_name = $1 AND labels = $2`
+	sqlSelectCounter   = `SELECT value FROM counters WHERE metric_name = $1 AND labels = $2`
+	sqlSelectHistogram = `SELECT bounds, counts, sum FROM histograms WHERE metric_name = $1 AND labels = $2`
+	sqlSelectSummary   = `
+		SELECT quantiles, quantile_values, sum, count FROM summaries WHERE metric_name = $1 AND labels = $2`
+	sqlSelectGauges     = `SELECT metric_name, labels, value FROM gauges`
+	sqlSelectCounters   = `SELECT metric_name, labels, value FROM counters`
+	sqlSelectHistograms = `SELECT metric_name, labels, bounds, counts, sum FROM histograms`
+	sqlSelectSummaries  = `SELECT metric_name, labels, quantiles, quantile_values, sum, count FROM summaries`
+	// Table of metric type is substituted from metricTables.
+	sqlDeleteMetric  = `DELETE FROM %s WHERE metric_name = $1 AND labels = $2`
+	sqlDeleteSamples = `DELETE FROM samples WHERE metric_type = $1 AND metric_name = $2 AND labels = $3`
+	sqlResetCounter  = `
+		WITH reset AS (
+			UPDATE counters SET value = 0, updated_at = now() WHERE metric_name = $1 AND labels = $2
+			RETURNING metric_name, labels, value
+		)
+		INSERT INTO samples(metric_type, metric_name, labels, value)
+		SELECT 'counter', metric_name, labels, value FROM reset`
+	sqlSelectResponse = `
+		SELECT request_hash, status, content_type, body FROM idempotency_keys
+		WHERE key = $1 AND expires_at > now()`
+	sqlDeleteExpiredResponses = `DELETE FROM idempotency_keys WHERE expires_at <= now()`
+	// Key is reserved with pending status, no row is returned if key is
+	// already reserved.
+	sqlReserveResponse = `
+		INSERT INTO idempotency_keys(key, request_hash, status, content_type, body, expires_at)
+		VALUES ($1, $2, $3, '', '', $4)
+		ON CONFLICT (key) DO NOTHING
+		RETURNING key`
+	sqlUpdateResponse = `
+		UPDATE idempotency_keys SET status = $3, content_type = $4, body = $5, expires_at = $6
+		WHERE key = $1 AND status = $2`
+	sqlDeleteResponse = `DELETE FROM idempotency_keys WHERE key = $1 AND status = $2`
+	dbQueryTimeout    = 1 * time.Second
+)
+
+// metricTables are tables of metrics by their types.
+var metricTables = map[string]string{
+	GAUGE:     "gauges",
+	COUNTER:   "counters",
+	HISTOGRAM: "histograms",
+	SUMMARY:   "summaries",
+}
+
+// dbStorage keeps metrics only in PostgreSQL, so several server instances
+// can share one data base. Like in memory, only historySize latest samples
+// of every metric are kept.
+type dbStorage struct {
+	db          *sql.DB
+	historySize int
+}
+
+func newDBStorage(db *sql.DB, historySize int) *dbStorage {
+	return &dbStorage{
+		db:          db,
+		historySize: historySize,
+	}
+}
+
+func (dbs *dbStorage) UpdateGauge(ctx context.Context, name string, labels map[string]string,
+	value float64) (saved float64, err error) {
+	err = retryDBOperation(retryDBWriteCount, func() error {
+		ctx, cancel := context.WithTimeout(ctx, dbQueryTimeout)
+		defer cancel()
+		return withTx(ctx, dbs.db, func(tx *sql.Tx) error {
+			err := tx.QueryRowContext(ctx, sqlUpsertGauge, name, types.FormatLabels(labels), value).Scan(&saved)
+			if err != nil {
+				return err
+			}
+			return pruneSamples(ctx, tx, GAUGE, []string{name}, []string{types.FormatLabels(labels)}, dbs.historySize)
+		})
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error in upsert gauge metric %s in DB: %w", name, err)
+	}
+	return saved, nil
+}
+
+func (dbs *dbStorage) AddCounter(ctx context.Context, name string, labels map[string]string,
+	delta int64) (saved int64, err error) {
+	err = retryDBOperation(retryDBWriteCount, func() error {
+		ctx, cancel := context.WithTimeout(ctx, dbQueryTimeout)
+		defer cancel()
+		// Delta is added and samples are pruned atomically, so retry after
+		// failed prune doesn't add delta twice.
+		return withTx(ctx, dbs.db, func(tx *sql.Tx) error {
+			err := tx.QueryRowContext(ctx, sqlUpsertCounter, name, types.FormatLabels(labels), delta).Scan(&saved)
+			if err != nil {
+				return err
+			}
+			return pruneSamples(ctx, tx, COUNTER, []string{name}, []string{types.FormatLabels(labels)}, dbs.historySize)
+		})
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error in upsert counter metric %s in DB: %w", name, err)
+	}
+	return saved, nil
+}
+
+// queryer is a data base or transaction.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// pruneSamples removes samples of metrics beyond historySize latest ones.
+func pruneSamples(ctx context.Context, db queryer, mType string, names, labels []string, historySize int) error {
+	_, err := db.ExecContext(ctx, sqlPruneSamples, mType, names, labels, max(historySize, 0))
+	if err != nil {
+		return fmt.Errorf("error in prune samples of %s metrics: %w", mType, err)
+	}
+	return nil
+}
+
+// upsertHistogram adds observations to histogram, histogram with other
+// bounds is rejected with types.ErrBoundsMismatch.
+func upsertHistogram(ctx context.Context, db queryer, name, labels string,
+	histogram types.Histogram) (types.Histogram, error) {
+	saved := types.Histogram{Bounds: histogram.Bounds}
+	// Arrays are scanned with help of pgx type map.
+	pgTypes := pgtype.NewMap()
+	err := db.QueryRowContext(ctx, sqlUpsertHistogram, name, labels, histogram.Bounds, histogram.Counts,
+		histogram.Sum).Scan(pgTypes.SQLScanner(&saved.Counts), &saved.Sum)
+	if errors.Is(err, sql.ErrNoRows) {
+		return saved, types.ErrBoundsMismatch
+	}
+	return saved, err
+}
+
+func upsertSummary(ctx context.Context, db queryer, name, labels string, summary types.Summary) error {
+	quantiles := make([]float64, 0, len(summary.Quantiles))
+	values := make([]float64, 0, len(summary.Quantiles))
+	for _, quantile := range summary.Quantiles {
+		quantiles = append(quantiles, quantile.Quantile)
+		values = append(values, quantile.Value)
+	}
+	_, err := db.ExecContext(ctx, sqlUpsertSummary, name, labels, quantiles, values, summary.Sum, summary.Count)
+	return err
+}
+
+func (dbs *dbStorage) AddHistogram(ctx context.Context, name string, labels map[string]string,
+	histogram types.Histogram) (saved types.Histogram, err error) {
+	err = retryDBOperation(retryDBWriteCount, func() error {
+		ctx, cancel := context.WithTimeout(ctx, dbQueryTimeout)
+		defer cancel()
+		saved, err = upsertHistogram(ctx, dbs.db, name, types.FormatLabels(labels), histogram)
+		return err
+	})
+	if err != nil {
+		return saved, fmt.Errorf("error in upsert histogram metric %s in DB: %w", name, err)
+	}
+	return saved, nil
+}
+
+func (dbs *dbStorage) UpdateSummary(ctx context.Context, name string, labels map[string]string,
+	summary types.Summary) (types.Summary, error) {
+	err := retryDBOperation(retryDBWriteCount, func() error {
+		ctx, cancel := context.WithTimeout(ctx, dbQueryTimeout)
+		defer cancel()
+		return upsertSummary(ctx, dbs.db, name, types.FormatLabels(labels), summary)
+	})
+	if err != nil {
+		return summary, fmt.Errorf("error in upsert summary metric %s in DB: %w", name, err)
+	}
+	return summary, nil
+}
+
+// summaryFromColumns joins quantiles and their values stored in separate
+// array columns.
+func summaryFromColumns(quantiles, values []float64, sum float64, count int64) types.Summary {
+	summary := types.Summary{Quantiles: make([]types.Quantile, 0, len(quantiles)), Sum: sum, Count: count}
+	for i, quantile := range quantiles {
+		summary.Quantiles = append(summary.Quantiles, types.Quantile{Quantile: quantile, Value: values[i]})
+	}
+	return summary
+}
+
+func (dbs *dbStorage) Get(ctx context.Context, mType, name string, labels map[string]string) (types.Metrics, error) {
+	metric := types.Metrics{
+		ID:     name,
+		MType:  mType,
+		Labels: labels,
+	}
+	ctx, cancel := context.WithTimeout(ctx, dbQueryTimeout)
+	defer cancel()
+
+	var err error
+	switch mType {
+	case GAUGE:
+		var value float64
+		err = dbs.db.QueryRowContext(ctx, sqlSelectGauge, name, types.FormatLabels(labels)).Scan(&value)
+		metric.Value = &value
+	case COUNTER:
+		var delta int64
+		err = dbs.db.QueryRowContext(ctx, sqlSelectCounter, name, types.FormatLabels(labels)).Scan(&delta)
+		metric.Delta = &delta
+	case HISTOGRAM:
+		var histogram types.Histogram
+		pgTypes := pgtype.NewMap()
+		err = dbs.db.QueryRowContext(ctx, sqlSelectHistogram, name, types.FormatLabels(labels)).Scan(
+			pgTypes.SQLScanner(&histogram.Bounds), pgTypes.SQLScanner(&histogram.Counts), &histogram.Sum)
+		metric.Histogram = &histogram
+	case SUMMARY:
+		var quantiles, values []float64
+		var sum float64
+		var count int64
+		pgTypes := pgtype.NewMap()
+		err = dbs.db.QueryRowContext(ctx, sqlSelectSummary, name, types.FormatLabels(labels)).Scan(
+			pgTypes.SQLScanner(&quantiles), pgTypes.SQLScanner(&values), &sum, &count)
+		summary := summaryFromColumns(quantiles, values, sum, count)
+		metric.Summary = &summary
+	default:
+		return metric, ErrUnknownType
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return metric, ErrMetricNotFound
+	} else if err != nil {
+		return metric, fmt.Errorf("error in select %s metric %s from DB: %w", mType, name, err)
+	}
+	return metric, nil
+}
+
+// scanMetricRow reads row of metric_name, labels and value columns into
+// metric.
+func scanMetricRow(rows *sql.Rows, metric *types.Metrics, values ...any) error {
+	var labels string
+	err := rows.Scan(append([]any{&metric.ID, &labels}, values...)...)
+	if err != nil {
+		return fmt.Errorf("error in scan %s row: %w", metric.MType, err)
+	}
+	if labels == "" {
+		return nil
+	}
+	metric.Labels, err = types.ParseLabels(labels)
+	if err != nil {
+		return fmt.Errorf("error in parse labels of %s %s: %w", metric.MType, metric.ID, err)
+	}
+	return nil
+}
+
+func (dbs *dbStorage) List(ctx context.Context, filter map[string]string) ([]types.Metrics, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbQueryTimeout)
 	defer cancel()
+
+	metrics := make([]types.Metrics, 0)
+	gaugeRows, err := dbs.db.QueryContext(ctx, sqlSelectGauges)
+	if err != nil {
+		return nil, fmt.Errorf("error in select gauges from DB: %w", err)
+	}
+	defer closeRows(gaugeRows)
+	for gaugeRows.Next() {
+		var value float64
+		metric := types.Metrics{MType: GAUGE}
+		err = scanMetricRow(gaugeRows, &metric, &value)
+		if err != nil {
+			return nil, err
+		}
+		if !types.MatchLabels(metric.Labels, filter) {
+			continue
+		}
+		metric.Value = &value
+		metrics = append(metrics, metric)
+	}
+	if err = gaugeRows.Err(); err != nil {
+		return nil, fmt.Errorf("error in iterate gauge rows: %w", err)
+	}
+
+	counterRows, err := dbs.db.QueryContext(ctx, sqlSelectCounters)
+	if err != nil {
+		return nil, fmt.Errorf("error in select counters from DB: %w", err)
+	}
+	defer closeRows(counterRows)
+	for counterRows.Next() {
+		var delta int64
+		metric := types.Metrics{MType: COUNTER}
+		err = scanMetricRow(counterRows, &metric, &delta)
+		if err != nil {
+			return nil, err
+		}
+		if !types.MatchLabels(metric.Labels, filter) {
+			continue
+		}
+		metric.Delta = &delta
+		metrics = append(metrics, metric)
+	}
+	if err = counterRows.Err(); err != nil {
+		return nil, fmt.Errorf("error in iterate counter rows: %w", err)
+	}
+
+	pgTypes := pgtype.NewMap()
+	histogramRows, err := dbs.db.QueryContext(ctx, sqlSelectHistograms)
+	if err != nil {
+		return nil, fmt.Errorf("error in select histograms from DB: %w", err)
+	}
+	defer closeRows(histogramRows)
+	for histogramRows.Next() {
+		var histogram types.Histogram
+		metric := types.Metrics{MType: HISTOGRAM}
+		err = scanMetricRow(histogramRows, &metric, pgTypes.SQLScanner(&histogram.Bounds),
+			pgTypes.SQLScanner(&histogram.Counts), &histogram.Sum)
+		if err != nil {
+			return nil, err
+		}
+		if !types.MatchLabels(metric.Labels, filter) {
+			continue
+		}
+		metric.Histogram = &histogram
+		metrics = append(metrics, metric)
+	}
+	if err = histogramRows.Err(); err != nil {
+		return nil, fmt.Errorf("error in iterate histogram rows: %w", err)
+	}
+
+	summaryRows, err := dbs.db.QueryContext(ctx, sqlSelectSummaries)
+	if err != nil {
+		return nil, fmt.Errorf("error in select summaries from DB: %w", err)
+	}
+	defer closeRows(summaryRows)
+	for summaryRows.Next() {
+		var quantiles, values []float64
+		var sum float64
+		var count int64
+		metric := types.Metrics{MType: SUMMARY}
+		err = scanMetricRow(summaryRows, &metric, pgTypes.SQLScanner(&quantiles), pgTypes.SQLScanner(&values),
+			&sum, &count)
+		if err != nil {
+			return nil, err
+		}
+		if !types.MatchLabels(metric.Labels, filter) {
+			continue
+		}
+		summary := summaryFromColumns(quantiles, values, sum, count)
+		metric.Summary = &summary
+		metrics = append(metrics, metric)
+	}
+	if err = summaryRows.Err(); err != nil {
+		return nil, fmt.Errorf("error in iterate summary rows: %w", err)
+	}
+	return metrics, nil
+}
+
+func (dbs *dbStorage) UpdateBatch(ctx context.Context, metrics []types.Metrics) ([]types.Metrics, error) {
+	for _, metric := range metrics {
+		switch metric.MType {
+		case GAUGE, COUNTER, HISTOGRAM, SUMMARY:
+		default:
+			return nil, fmt.Errorf("%w, got %s", ErrUnknownType, metric.MType)
+		}
+	}
+	err := retryDBOperation(retryDBWriteCount, func() error {
+		return writeMetricBatchDB(ctx, dbs.db, metrics, dbs.historySize)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to write metrics in DB: %w", err)
+	}
+	return metrics, nil
+}
+
+func (dbs *dbStorage) QueryRange(ctx context.Context, mType, name string, labels map[string]string,
+	from, to time.Time) ([]types.Sample, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbQueryTimeout)
+	defer cancel()
+
+	rows, err := dbs.db.QueryContext(ctx, sqlSelectSamples, mType, name, types.FormatLabels(labels), from, to,
+		maxQueryPoints)
+	if err != nil {
+		return nil, fmt.Errorf("error in select samples of %s metric %s: %w", mType, name, err)
+	}
+	defer closeRows(rows)
+
+	samples := make([]types.Sample, 0)
+	for rows.Next() {
+		var sample types.Sample
+		err = rows.Scan(&sample.Timestamp, &sample.Value)
+		if err != nil {
+			return nil, fmt.Errorf("error in scan sample row: %w", err)
+		}
+		samples = append(samples, sample)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error in iterate sample rows: %w", err)
+	}
+	return samples, nil
+}
+
+func (dbs *dbStorage) LoadResponse(ctx context.Context, key string) (storedResponse, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbQueryTimeout)
+	defer cancel()
+
+	var response storedResponse
+	err := dbs.db.QueryRowContext(ctx, sqlSelectResponse, key).Scan(
+		&response.RequestHash, &response.Status, &response.ContentType, &response.Body)
+	if errors.Is(err, sql.ErrNoRows) {
+		return response, false, nil
+	} else if err != nil {
+		return response, false, fmt.Errorf("error in select response of idempotency key: %w", err)
+	}
+	return response, true, nil
+}
+
+// ReserveResponse forgets expired keys and reserves key with one insert, so
+// only one of servers sharing data base processes request. Key which is
+// reserved, but released or expired meanwhile, is returned as pending and
+// caller tries again.
+func (dbs *dbStorage) ReserveResponse(ctx context.Context, key, requestHash string,
+	expiresAt time.Time) (storedResponse, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbQueryTimeout)
+	defer cancel()
+
+	pending := storedResponse{Status: pendingResponseStatus}
+	_, err := dbs.db.ExecContext(ctx, sqlDeleteExpiredResponses)
+	if err != nil {
+		return pending, false, fmt.Errorf("error in delete expired idempotency keys: %w", err)
+	}
+	var reserved string
+	err = dbs.db.QueryRowContext(ctx, sqlReserveResponse, key, requestHash, pendingResponseStatus,
+		expiresAt).Scan(&reserved)
+	if err == nil {
+		return pending, true, nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return pending, false, fmt.Errorf("error in reserve idempotency key: %w", err)
+	}
+	response, found, err := dbs.LoadResponse(ctx, key)
+	if err != nil || !found {
+		return pending, false, err
+	}
+	return response, false, nil
+}
+
+// SaveResponse remembers response of key reserved by ReserveResponse.
+func (dbs *dbStorage) SaveResponse(ctx context.Context, key string, response storedResponse,
+	expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, dbQueryTimeout)
+	defer cancel()
+
+	_, err := dbs.db.ExecContext(ctx, sqlUpdateResponse, key, pendingResponseStatus, response.Status,
+		response.ContentType, response.Body, expiresAt)
+	if err != nil {
+		return fmt.Errorf("error in save response of idempotency key: %w", err)
+	}
+	return nil
+}
+
+// ReleaseResponse forgets key reserved by ReserveResponse.
+func (dbs *dbStorage) ReleaseResponse(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbQueryTimeout)
+	defer cancel()
+
+	_, err := dbs.db.ExecContext(ctx, sqlDeleteResponse, key, pendingResponseStatus)
+	if err != nil {
+		return fmt.Errorf("error in release idempotency key: %w", err)
+	}
+	return nil
+}
+
+// withTx runs operation in transaction which is committed if operation
+// succeeds.
+func withTx(ctx context.Context, db *sql.DB, operation func(tx *sql.Tx) error) (err error) {
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("error in create transaction for DB: %w", err)
 	}
 	defer func() {
-		err = tx.Rollback()
+		rollbackErr := tx.Rollback()
+		if rollbackErr != nil && !errors.Is(rollbackErr, sql.ErrTxDone) {
+			err = rollbackErr
+		}
 	}()
+	err = operation(tx)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error in commit transaction to DB: %w", err)
+	}
+	return nil
+}
+
+// deleteMetricDB removes metric with its samples and reports whether metric
+// existed.
+func deleteMetricDB(ctx context.Context, db queryer, mType, name, labels string) (bool, error) {
+	table, ok := metricTables[mType]
+	if !ok {
+		return false, fmt.Errorf("%w, got %s", ErrUnknownType, mType)
+	}
+	result, err := db.ExecContext(ctx, fmt.Sprintf(sqlDeleteMetric, table), name, labels)
+	if err != nil {
+		return false, fmt.Errorf("error in delete %s metric %s: %w", mType, name, err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error in get number of deleted rows: %w", err)
+	}
+	if deleted == 0 {
+		return false, nil
+	}
+	_, err = db.ExecContext(ctx, sqlDeleteSamples, mType, name, labels)
+	if err != nil {
+		return false, fmt.Errorf("error in delete samples of %s metric %s: %w", mType, name, err)
+	}
+	return true, nil
+}
+
+func (dbs *dbStorage) Delete(ctx context.Context, mType, name string, labels map[string]string) error {
+	var found bool
+	err := retryDBOperation(retryDBWriteCount, func() error {
+		ctx, cancel := context.WithTimeout(ctx, dbQueryTimeout)
+		defer cancel()
+		return withTx(ctx, dbs.db, func(tx *sql.Tx) (err error) {
+			found, err = deleteMetricDB(ctx, tx, mType, name, types.FormatLabels(labels))
+			return err
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("error in delete %s metric %s from DB: %w", mType, name, err)
+	}
+	if !found {
+		return ErrMetricNotFound
+	}
+	return nil
+}
+
+// DeleteMatching removes all matched metrics in one transaction.
+func (dbs *dbStorage) DeleteMatching(ctx context.Context, mType, pattern string,
+	filter map[string]string) ([]types.Metrics, error) {
+	metrics, err := dbs.List(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	matched, err := matchMetrics(metrics, mType, pattern)
+	if err != nil {
+		return nil, err
+	}
+	var deleted []types.Metrics
+	err = retryDBOperation(retryDBWriteCount, func() error {
+		ctx, cancel := context.WithTimeout(ctx, dbQueryTimeout)
+		defer cancel()
+		deleted = make([]types.Metrics, 0, len(matched))
+		return withTx(ctx, dbs.db, func(tx *sql.Tx) error {
+			for _, metric := range matched {
+				found, err := deleteMetricDB(ctx, tx, metric.MType, metric.ID, types.FormatLabels(metric.Labels))
+				if err != nil {
+					return err
+				}
+				if found {
+					deleted = append(deleted, metric)
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in delete metrics matching %s from DB: %w", pattern, err)
+	}
+	return deleted, nil
+}
+
+func (dbs *dbStorage) ResetCounter(ctx context.Context, name string, labels map[string]string) error {
+	var reset int64
+	err := retryDBOperation(retryDBWriteCount, func() error {
+		ctx, cancel := context.WithTimeout(ctx, dbQueryTimeout)
+		defer cancel()
+		return withTx(ctx, dbs.db, func(tx *sql.Tx) error {
+			result, err := tx.ExecContext(ctx, sqlResetCounter, name, types.FormatLabels(labels))
+			if err != nil {
+				return err
+			}
+			reset, err = result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			return pruneSamples(ctx, tx, COUNTER, []string{name}, []string{types.FormatLabels(labels)}, dbs.historySize)
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("error in reset counter metric %s in DB: %w", name, err)
+	}
+	if reset == 0 {
+		return ErrMetricNotFound
+	}
+	return nil
+}
+
+func (dbs *dbStorage) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, dbQueryTimeout)
+	defer cancel()
+	err := dbs.db.PingContext(ctx)
+	if err != nil {
+		return fmt.Errorf("DB is unreachable: %w", err)
+	}
+	return nil
+}
+
+func (dbs *dbStorage) Close() error {
+	err := dbs.db.Close()
+	if err != nil {
+		return fmt.Errorf("error in close connection with DB: %w", err)
+	}
+	return nil
+}
+
+func closeRows(rows *sql.Rows) {
+	_ = rows.Close()
+}
+
+// seriesRecord is a metric of batch collapsed by series.
+type seriesRecord struct {
+	histogram *types.Histogram
+	summary   *types.Summary
+	name      string
+	labels    string
+	value     float64
+	delta     int64
+}
+
+// writeMetricBatchDB applies the whole batch in one transaction. Gauges of the
+// same series are collapsed to the last value and counter deltas are summed,
+// because one upsert statement can't touch the same row twice.
+func writeMetricBatchDB(ctx context.Context, db *sql.DB, metrics []types.Metrics, historySize int) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, dbQueryTimeout)
+	defer cancel()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error in create transaction for DB: %w", err)
+	}
+	defer func() {
+		rollbackErr := tx.Rollback()
+		if rollbackErr != nil && !errors.Is(rollbackErr, sql.ErrTxDone) {
+			err = rollbackErr
+		}
+	}()
+
+	gauges := make(map[string]*seriesRecord)
+	counters := make(map[string]*seriesRecord)
+	histograms := make(map[string]*seriesRecord)
+	summaries := make(map[string]*seriesRecord)
+	for _, metric := range metrics {
+		key := types.SeriesKey(metric.ID, metric.Labels)
+		switch metric.MType {
+		case HISTOGRAM:
+			record, ok := histograms[key]
+			if !ok {
+				histogram := metric.Histogram.Clone()
+				histograms[key] = &seriesRecord{name: metric.ID, labels: types.FormatLabels(metric.Labels),
+					histogram: &histogram}
+				continue
+			}
+			err = record.histogram.Merge(*metric.Histogram)
+			if err != nil {
+				return fmt.Errorf("%w: %s", err, metric.ID)
+			}
+		case SUMMARY:
+			summaries[key] = &seriesRecord{name: metric.ID, labels: types.FormatLabels(metric.Labels),
+				summary: metric.Summary}
+		case GAUGE:
+			gauges[key] = &seriesRecord{name: metric.ID, labels: types.FormatLabels(metric.Labels), value: *metric.Value}
+		case COUNTER:
+			record, ok := counters[key]
+			if !ok {
+				record = &seriesRecord{name: metric.ID, labels: types.FormatLabels(metric.Labels)}
+				counters[key] = record
+			}
+			record.delta += *metric.Delta
+		}
+	}
 
 	giq := types.NewGaugeInsertQuery()
-	for k, v := range storage.GetGauges() {
-		giq.AddRecord(k, v)
+	for _, record := range gauges {
+		giq.AddRecord(record.name, record.labels, record.value)
 	}
 	err = giq.ExecInsert(ctx, tx)
 	if err != nil {
 		return fmt.Errorf("error in execution new record in Gauge metric table in PostgreSQL: %w", err)
 	}
 	ciq := types.NewCounterInsertQuery()
-	for k, v := range storage.GetCounters() {
-		ciq.AddRecord(k, v)
+	for _, record := range counters {
+		ciq.AddRecord(record.name, record.labels, record.delta)
 	}
 	err = ciq.ExecInsert(ctx, tx)
 	if err != nil {
 		return fmt.Errorf("error in execution new record in Counter metric table in PostgreSQL: %w", err)
 	}
 
+	for _, record := range histograms {
+		_, err = upsertHistogram(ctx, tx, record.name, record.labels, *record.histogram)
+		if err != nil {
+			return fmt.Errorf("error in upsert histogram %s in PostgreSQL: %w", record.name, err)
+		}
+	}
+	for _, record := range summaries {
+		err = upsertSummary(ctx, tx, record.name, record.labels, *record.summary)
+		if err != nil {
+			return fmt.Errorf("error in upsert summary %s in PostgreSQL: %w", record.name, err)
+		}
+	}
+
+	err = insertBatchSamples(ctx, tx, sqlInsertGaugeSamples, GAUGE, gauges, historySize)
+	if err != nil {
+		return fmt.Errorf("error in insert gauge samples in PostgreSQL: %w", err)
+	}
+	err = insertBatchSamples(ctx, tx, sqlInsertCounterSamples, COUNTER, counters, historySize)
+	if err != nil {
+		return fmt.Errorf("error in insert counter samples in PostgreSQL: %w", err)
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return fmt.Errorf("error in commit transaction to DB: %w", err)
@@ -47,7 +792,29 @@ func writeMetricStorageDB(db *sql.DB, storage *types.MemStorage) (err error) {
 	return nil
 }
 
-func retryDBWrite(db *sql.DB, storage *types.MemStorage, retryCount int) (err error) {
+// insertBatchSamples records saved values of metrics and prunes their old
+// samples.
+func insertBatchSamples(ctx context.Context, tx *sql.Tx, query, mType string, records map[string]*seriesRecord,
+	historySize int) error {
+	if len(records) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(records))
+	labels := make([]string, 0, len(records))
+	for _, record := range records {
+		names = append(names, record.name)
+		labels = append(labels, record.labels)
+	}
+	_, err := tx.ExecContext(ctx, query, names, labels)
+	if err != nil {
+		return fmt.Errorf("error in insert samples: %w", err)
+	}
+	return pruneSamples(ctx, tx, mType, names, labels, historySize)
+}
+
+// retryDBOperation repeats operation while PostgreSQL returns an error,
+// waiting 1, 3, 5... seconds between attempts.
+func retryDBOperation(retryCount int, operation func() error) (err error) {
 	var pgErr *pgconn.PgError
 	delays := make([]time.Duration, 0, retryCount)
 	delays = append(delays, 0*time.Second)
@@ -57,7 +824,7 @@ func retryDBWrite(db *sql.DB, storage *types.MemStorage, retryCount int) (err er
 
 	for i := 0; i < retryCount; i++ {
 		time.Sleep(delays[i])
-		err = writeMetricStorageDB(db, storage)
+		err = operation()
 		if err == nil || !errors.As(err, &pgErr) {
 			break
 		}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xChygyNx/metrical/internal/server/migrations"
+	"github.com/xChygyNx/metrical/internal/server/types"
+)
+
+// newTestDBStorage connects to PostgreSQL from DATABASE_DSN in a new schema
+// with applied migrations, which is dropped after test, so tables of data
+// base are not touched.
+func newTestDBStorage(t *testing.T, historySize int) *dbStorage {
+	t.Helper()
+	dsn := os.Getenv("DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("DATABASE_DSN is not set")
+	}
+	admin, err := sql.Open("pgx", dsn)
+	require.NoError(t, err)
+	schema := fmt.Sprintf("storage_test_%d", time.Now().UnixNano())
+	_, err = admin.Exec("CREATE SCHEMA " + schema)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_, _ = admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		_ = admin.Close()
+	})
+
+	config, err := pgx.ParseConfig(dsn)
+	require.NoError(t, err)
+	config.RuntimeParams["search_path"] = schema
+	db := stdlib.OpenDB(*config)
+	require.NoError(t, migrations.Up(context.Background(), db))
+	storage := newDBStorage(db, historySize)
+	t.Cleanup(func() {
+		_ = storage.Close()
+	})
+	return storage
+}
+
+func TestDBStorage(t *testing.T) {
+	storage := newTestDBStorage(t, 2)
+	ctx := context.Background()
+	labels := map[string]string{agentLabel: "web1"}
+
+	for _, value := range []float64{1, 2, 3} {
+		saved, err := storage.UpdateGauge(ctx, "Alloc", nil, value)
+		require.NoError(t, err)
+		assert.InDelta(t, value, saved, 0)
+	}
+	_, err := storage.AddCounter(ctx, "PollCount", labels, 5)
+	require.NoError(t, err)
+	delta, err := storage.AddCounter(ctx, "PollCount", labels, 1<<60)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5+1<<60), delta, "counter is bigint")
+
+	value, batchDelta := 4.0, int64(2)
+	_, err = storage.UpdateBatch(ctx, []types.Metrics{
+		{ID: "Alloc", MType: GAUGE, Value: &value},
+		{ID: "PollCount", MType: COUNTER, Labels: labels, Delta: &batchDelta},
+		{ID: "PollCount", MType: COUNTER, Labels: labels, Delta: &batchDelta},
+	})
+	require.NoError(t, err)
+
+	metric, err := storage.Get(ctx, GAUGE, "Alloc", nil)
+	require.NoError(t, err)
+	assert.InDelta(t, 4.0, *metric.Value, 0)
+	metric, err = storage.Get(ctx, COUNTER, "PollCount", labels)
+	require.NoError(t, err)
+	assert.Equal(t, int64(9+1<<60), *metric.Delta)
+	_, err = storage.Get(ctx, COUNTER, "PollCount", nil)
+	assert.ErrorIs(t, err, ErrMetricNotFound, "series are separated by labels")
+
+	samples, err := storage.QueryRange(ctx, GAUGE, "Alloc", nil, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, samples, 2, "only history size samples are kept")
+	assert.InDelta(t, 3.0, samples[0].Value, 0)
+	assert.InDelta(t, 4.0, samples[1].Value, 0)
+
+	histogram := types.Histogram{Bounds: []float64{1}, Counts: []int64{1, 2}, Sum: 3}
+	_, err = storage.AddHistogram(ctx, "latency", nil, histogram)
+	require.NoError(t, err)
+	saved, err := storage.AddHistogram(ctx, "latency", nil, histogram)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{2, 4}, saved.Counts)
+	_, err = storage.AddHistogram(ctx, "latency", nil, types.Histogram{Bounds: []float64{2}, Counts: []int64{1, 1}})
+	assert.ErrorIs(t, err, types.ErrBoundsMismatch)
+
+	require.NoError(t, storage.ResetCounter(ctx, "PollCount", labels))
+	metric, err = storage.Get(ctx, COUNTER, "PollCount", labels)
+	require.NoError(t, err)
+	assert.Zero(t, *metric.Delta)
+
+	require.NoError(t, storage.Delete(ctx, GAUGE, "Alloc", nil))
+	assert.ErrorIs(t, storage.Delete(ctx, GAUGE, "Alloc", nil), ErrMetricNotFound)
+	metrics, err := storage.List(ctx, nil)
+	require.NoError(t, err)
+	assert.Len(t, metrics, 2)
+}
+
+func TestDBReserveResponse(t *testing.T) {
+	storage := newTestDBStorage(t, 2)
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Minute)
+
+	_, reserved, err := storage.ReserveResponse(ctx, "report-1", "hash", expiresAt)
+	require.NoError(t, err)
+	assert.True(t, reserved)
+	stored, reserved, err := storage.ReserveResponse(ctx, "report-1", "hash", expiresAt)
+	require.NoError(t, err)
+	assert.False(t, reserved, "key is reserved once")
+	assert.Equal(t, pendingResponseStatus, stored.Status)
+
+	require.NoError(t, storage.ReleaseResponse(ctx, "report-1"))
+	_, reserved, err = storage.ReserveResponse(ctx, "report-1", "hash", expiresAt)
+	require.NoError(t, err)
+	assert.True(t, reserved, "released key is reserved again")
+
+	response := storedResponse{
+		RequestHash: "hash", ContentType: jsonContentType, Body: []byte("{}"), Status: http.StatusOK,
+	}
+	require.NoError(t, storage.SaveResponse(ctx, "report-1", response, expiresAt))
+	require.NoError(t, storage.ReleaseResponse(ctx, "report-1"))
+	stored, reserved, err = storage.ReserveResponse(ctx, "report-1", "hash", expiresAt)
+	require.NoError(t, err)
+	assert.False(t, reserved)
+	assert.Equal(t, response, stored, "saved response isn't released")
+
+	_, reserved, err = storage.ReserveResponse(ctx, "report-2", "hash", time.Now().Add(-time.Second))
+	require.NoError(t, err)
+	require.True(t, reserved)
+	_, reserved, err = storage.ReserveResponse(ctx, "report-2", "hash", expiresAt)
+	require.NoError(t, err)
+	assert.True(t, reserved, "expired key is reserved again")
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/xChygyNx/metrical/internal/server/types"
+)
+
+const (
+	authorizationHeader = "Authorization"
+	bearerPrefix        = "Bearer "
+	adminDisabledMsg    = "admin requests are disabled, admin token is not set"
+	badAdminTokenMsg    = "missing or invalid admin token"
+	patternParam        = "pattern"
+)
+
+// adminAuth lets through only requests with admin token in Authorization
+// header. All admin requests are forbidden if token is not set.
+func adminAuth(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			if token == "" {
+				writeError(res, adminDisabledMsg, http.StatusForbidden)
+				return
+			}
+			given, ok := strings.CutPrefix(req.Header.Get(authorizationHeader), bearerPrefix)
+			if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				res.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(res, badAdminTokenMsg, http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(res, req)
+		})
+	}
+}
+
+func writeJSON(res http.ResponseWriter, data any) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		errorMsg := fmt.Errorf("error in serialize response for send by server: %w", err).Error()
+		log.Println(errorMsg)
+		writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
+		return
+	}
+	res.Header().Set(contentType, jsonContentType)
+	res.WriteHeader(http.StatusOK)
+	_, err = res.Write(encoded)
+	if err != nil {
+		errorMsg := fmt.Errorf(errorMsgWildcard, writeHandlerErrorMsg, err).Error()
+		log.Println(errorMsg)
+	}
+}
+
+// DeleteMetricHandle removes metric of type and name from path and labels
+// from query.
+func DeleteMetricHandle(storage Storage) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		metricType := req.PathValue("mType")
+		err := types.ValidateType(metricType)
+		if err != nil {
+			writeBadRequest(res, err)
+			return
+		}
+		metricName := req.PathValue("metric")
+		err = types.ValidateName(metricName)
+		if err != nil {
+			writeBadRequest(res, err)
+			return
+		}
+		labels, err := parseLabelParams(req)
+		if err != nil {
+			writeBadRequest(res, err)
+			return
+		}
+
+		err = storage.Delete(req.Context(), metricType, metricName, labels)
+		if errors.Is(err, ErrMetricNotFound) {
+			writeError(res, "Metric "+metricName+" not set", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Println(err)
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(res, types.Metrics{ID: metricName, MType: metricType, Labels: labels})
+	}
+}
+
+// DeleteMetricsHandle removes metrics whose names match glob pattern from
+// query, like Heap* or test_?. Optional type and labels of query narrow
+// deleted metrics.
+func DeleteMetricsHandle(storage Storage) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		params := req.URL.Query()
+		pattern := params.Get(patternParam)
+		if pattern == "" {
+			writeFieldError(res, "is required, use * to delete all metrics", patternParam, http.StatusBadRequest)
+			return
+		}
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			writeFieldError(res, "must be glob pattern like Heap*, got "+pattern, patternParam, http.StatusBadRequest)
+			return
+		}
+		metricType := params.Get("type")
+		if metricType != "" {
+			err = types.ValidateType(metricType)
+			if err != nil {
+				writeBadRequest(res, err)
+				return
+			}
+		}
+		filter, err := parseLabelParams(req)
+		if err != nil {
+			writeBadRequest(res, err)
+			return
+		}
+
+		deleted, err := storage.DeleteMatching(req.Context(), metricType, pattern, filter)
+		if err != nil {
+			log.Println(err)
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(res, types.DeleteResult{Deleted: deleted, Count: len(deleted)})
+	}
+}
+
+// ResetCounterHandle sets counter with name from path and labels from query
+// to zero.
+func ResetCounterHandle(storage Storage) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		metricName := req.PathValue("metric")
+		err := types.ValidateName(metricName)
+		if err != nil {
+			writeBadRequest(res, err)
+			return
+		}
+		labels, err := parseLabelParams(req)
+		if err != nil {
+			writeBadRequest(res, err)
+			return
+		}
+
+		err = storage.ResetCounter(req.Context(), metricName, labels)
+		if errors.Is(err, ErrMetricNotFound) {
+			writeError(res, "Metric "+metricName+" not set", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Println(err)
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
+			return
+		}
+		var zero int64
+		writeJSON(res, types.Metrics{ID: metricName, MType: COUNTER, Labels: labels, Delta: &zero})
+	}
+}
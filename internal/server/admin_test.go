@@ -0,0 +1,195 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/xChygyNx/metrical/internal/server/types"
+)
+
+const testAdminToken = "secret"
+
+func newAdminRouter(storage Storage, token string) http.Handler {
+	config := &Config{AgentStaleTimeout: defaultAgentStaleTimeout, AdminToken: token}
+	return newRouter(config, storage, types.NewAgentRegistry(), *zap.NewNop().Sugar())
+}
+
+func sendAdmin(handler http.Handler, method, url, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, http.NoBody)
+	if token != "" {
+		req.Header.Set(authorizationHeader, bearerPrefix+token)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// fillStorage saves gauges Alloc, HeapAlloc, HeapSys, counter PollCount and
+// counter PollCount of agent web1.
+func fillStorage(t *testing.T, storage Storage) {
+	t.Helper()
+	ctx := context.Background()
+	for _, name := range []string{"Alloc", "HeapAlloc", "HeapSys"} {
+		_, err := storage.UpdateGauge(ctx, name, nil, 1)
+		require.NoError(t, err)
+	}
+	_, err := storage.AddCounter(ctx, "PollCount", nil, 5)
+	require.NoError(t, err)
+	_, err = storage.AddCounter(ctx, "PollCount", map[string]string{agentLabel: "web1"}, 7)
+	require.NoError(t, err)
+}
+
+func TestAdminAuth(t *testing.T) {
+	tests := []struct {
+		name        string
+		serverToken string
+		token       string
+		status      int
+	}{
+		{name: "Admin token is not set", token: testAdminToken, status: http.StatusForbidden},
+		{name: "Missing token", serverToken: testAdminToken, status: http.StatusUnauthorized},
+		{name: "Wrong token", serverToken: testAdminToken, token: "guess", status: http.StatusUnauthorized},
+		{name: "Right token", serverToken: testAdminToken, token: testAdminToken, status: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage := newMemStorage(defaultHistorySize)
+			fillStorage(t, storage)
+			router := newAdminRouter(storage, test.serverToken)
+
+			rec := sendAdmin(router, http.MethodDelete, "/value/gauge/Alloc", test.token)
+			assert.Equal(t, test.status, rec.Code)
+			_, err := storage.Get(context.Background(), GAUGE, "Alloc", nil)
+			if test.status == http.StatusOK {
+				assert.ErrorIs(t, err, ErrMetricNotFound)
+			} else {
+				assert.NoError(t, err, "metric isn't deleted without admin token")
+			}
+		})
+	}
+}
+
+func TestDeleteMetrics(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		left   []string
+		status int
+		count  int
+	}{
+		{
+			name:   "Delete one metric",
+			method: http.MethodDelete,
+			url:    "/value/gauge/HeapSys",
+			status: http.StatusOK,
+			left:   []string{"Alloc", "HeapAlloc", "PollCount", "PollCount{agent=\"web1\"}"},
+		},
+		{
+			name:   "Delete labeled metric",
+			method: http.MethodDelete,
+			url:    "/value/counter/PollCount?label=agent=web1",
+			status: http.StatusOK,
+			left:   []string{"Alloc", "HeapAlloc", "HeapSys", "PollCount"},
+		},
+		{
+			name:   "Delete not saved metric",
+			method: http.MethodDelete,
+			url:    "/value/counter/HeapSys",
+			status: http.StatusNotFound,
+			left:   []string{"Alloc", "HeapAlloc", "HeapSys", "PollCount", "PollCount{agent=\"web1\"}"},
+		},
+		{
+			name:   "Delete by pattern",
+			method: http.MethodDelete,
+			url:    "/value?pattern=Heap*",
+			status: http.StatusOK,
+			count:  2,
+			left:   []string{"Alloc", "PollCount", "PollCount{agent=\"web1\"}"},
+		},
+		{
+			name:   "Delete by pattern, type and label",
+			method: http.MethodDelete,
+			url:    "/value/?pattern=*&type=counter&label=agent=web1",
+			status: http.StatusOK,
+			count:  1,
+			left:   []string{"Alloc", "HeapAlloc", "HeapSys", "PollCount"},
+		},
+		{
+			name:   "Pattern is required",
+			method: http.MethodDelete,
+			url:    "/value",
+			status: http.StatusBadRequest,
+			left:   []string{"Alloc", "HeapAlloc", "HeapSys", "PollCount", "PollCount{agent=\"web1\"}"},
+		},
+		{
+			name:   "Malformed pattern",
+			method: http.MethodDelete,
+			url:    "/value?pattern=Heap[",
+			status: http.StatusBadRequest,
+			left:   []string{"Alloc", "HeapAlloc", "HeapSys", "PollCount", "PollCount{agent=\"web1\"}"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage := newMemStorage(defaultHistorySize)
+			fillStorage(t, storage)
+			router := newAdminRouter(storage, testAdminToken)
+
+			rec := sendAdmin(router, test.method, test.url, testAdminToken)
+			assert.Equal(t, test.status, rec.Code)
+			if test.count != 0 {
+				var result types.DeleteResult
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+				assert.Equal(t, test.count, result.Count)
+				assert.Len(t, result.Deleted, test.count)
+			}
+
+			metrics, err := storage.List(context.Background(), nil)
+			require.NoError(t, err)
+			left := make([]string, 0, len(metrics))
+			for _, metric := range metrics {
+				left = append(left, types.SeriesKey(metric.ID, metric.Labels))
+			}
+			assert.ElementsMatch(t, test.left, left)
+		})
+	}
+}
+
+func TestResetCounter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	storage := newFileStorage(newMemStorage(defaultHistorySize), path, 0)
+	fillStorage(t, storage)
+	router := newAdminRouter(storage, testAdminToken)
+
+	rec := sendAdmin(router, http.MethodPost, "/reset/counter/PollCount", testAdminToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = sendAdmin(router, http.MethodPost, "/reset/counter/Alloc", testAdminToken)
+	assert.Equal(t, http.StatusNotFound, rec.Code, "gauge isn't reset")
+	rec = sendAdmin(router, http.MethodDelete, "/value/gauge/HeapSys", testAdminToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	metric, err := storage.Get(context.Background(), COUNTER, "PollCount", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), *metric.Delta)
+	metric, err = storage.Get(context.Background(), COUNTER, "PollCount", map[string]string{agentLabel: "web1"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), *metric.Delta, "counter with other labels isn't reset")
+
+	require.NoError(t, storage.Close())
+	restored := types.GetMemStorage()
+	require.NoError(t, restoreMetricStore(path, restored))
+	counter, ok := restored.GetCounter("PollCount")
+	assert.True(t, ok)
+	assert.Equal(t, int64(0), counter, "reset is saved in file")
+	_, ok = restored.GetGauge("HeapSys")
+	assert.False(t, ok, "delete is saved in file")
+}
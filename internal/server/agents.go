@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/xChygyNx/metrical/internal/server/types"
+)
+
+const (
+	// Metrics of identified agents are stored with this label.
+	agentLabel     = "agent"
+	maxAgentIDSize = 100
+)
+
+type agentIDKey struct{}
+
+// agentIdentity remembers agents which send X-Agent-ID header with write
+// requests and passes their ID to handlers through request context. Agent with verified client
+// certificate is identified by its common name instead of header.
+func agentIdentity(agents *types.AgentRegistry) func(http.Handler) http.Handler {
+	return func(internal http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			agentID := req.Header.Get(types.AgentIDHeader)
+			if req.TLS != nil && len(req.TLS.VerifiedChains) > 0 {
+				if commonName := req.TLS.VerifiedChains[0][0].Subject.CommonName; commonName != "" {
+					agentID = commonName
+				}
+			}
+			if agentID == "" {
+				internal.ServeHTTP(res, req)
+				return
+			}
+			if len(agentID) > maxAgentIDSize {
+				errorMsg := fmt.Sprintf("%s header must be at most %d bytes", types.AgentIDHeader, maxAgentIDSize)
+				writeFieldError(res, errorMsg, types.AgentIDHeader, http.StatusBadRequest)
+				return
+			}
+			agents.Seen(agentID, req.RemoteAddr, time.Now())
+			ctx := context.WithValue(req.Context(), agentIDKey{}, agentID)
+			internal.ServeHTTP(res, req.WithContext(ctx))
+		})
+	}
+}
+
+func agentFromContext(ctx context.Context) string {
+	agentID, _ := ctx.Value(agentIDKey{}).(string)
+	return agentID
+}
+
+// withAgentLabel namespaces metric of identified agent by agent label. Agent
+// can't write metrics under label of another agent.
+func withAgentLabel(ctx context.Context, labels map[string]string) (map[string]string, error) {
+	agentID := agentFromContext(ctx)
+	if agentID == "" {
+		return labels, nil
+	}
+	if value, ok := labels[agentLabel]; ok {
+		if value != agentID {
+			errorMsg := "must match ID of sending agent " + agentID + ", got " + value
+			return nil, &types.ValidationError{Field: "labels." + agentLabel, Message: errorMsg}
+		}
+		return labels, nil
+	}
+	namespaced := make(map[string]string, len(labels)+1)
+	for name, value := range labels {
+		namespaced[name] = value
+	}
+	namespaced[agentLabel] = agentID
+	return namespaced, nil
+}
+
+func ListAgentsHandle(agents *types.AgentRegistry, staleAfter time.Duration) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set(contentType, jsonContentType)
+
+		responseData, err := json.Marshal(agents.List(time.Now(), staleAfter))
+		if err != nil {
+			errorMsg := fmt.Errorf("error in serialize list of agents: %w", err).Error()
+			log.Println(errorMsg)
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
+			return
+		}
+
+		res.WriteHeader(http.StatusOK)
+		_, err = res.Write(responseData)
+		if err != nil {
+			errorMsg := fmt.Errorf(errorMsgWildcard, writeHandlerErrorMsg, err).Error()
+			log.Println(errorMsg)
+			return
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/xChygyNx/metrical/internal/server/types"
+)
+
+// writeError replies to request with JSON error body, it is used like
+// http.Error.
+func writeError(res http.ResponseWriter, message string, code int) {
+	writeFieldError(res, message, "", code)
+}
+
+// writeFieldError replies with JSON error body which names invalid field.
+func writeFieldError(res http.ResponseWriter, message, field string, code int) {
+	body, err := json.Marshal(types.ErrorResponse{Code: code, Message: message, Field: field})
+	if err != nil {
+		log.Println(err)
+		http.Error(res, internalServerErrorMsg, http.StatusInternalServerError)
+		return
+	}
+	header := res.Header()
+	header.Del("Content-Length")
+	header.Set(contentType, jsonContentType)
+	header.Set("X-Content-Type-Options", "nosniff")
+	res.WriteHeader(code)
+	_, err = res.Write(body)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// writeBadRequest replies with 400 status, invalid field is named if err is
+// types.ValidationError.
+func writeBadRequest(res http.ResponseWriter, err error) {
+	var validationErr *types.ValidationError
+	if errors.As(err, &validationErr) {
+		writeFieldError(res, validationErr.Message, validationErr.Field, http.StatusBadRequest)
+		return
+	}
+	writeError(res, err.Error(), http.StatusBadRequest)
+}
@@ -10,20 +10,13 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/xChygyNx/metrical/internal/server/types"
+	"github.com/xChygyNx/metrical/internal/server/migrations"
 )
 
 const (
-	sqlCreateGaugeTableCmd = `
-		CREATE TABLE IF NOT EXISTS gauges (
-			metric_name		varchar(100) PRIMARY KEY,
-			value			double precision NOT NULL
-		);`
-	sqlCreateCounterTableCmd = `
-		CREATE TABLE IF NOT EXISTS counters (
-			metric_name		varchar(100) PRIMARY KEY,
-			value			integer NOT NULL
-		);`
+	defaultHistorySize       = 1000
+	defaultAgentStaleTimeout = 60
+	defaultIdempotencyTTL    = 3600
 )
 
 type HostPort struct {
@@ -32,11 +25,25 @@ type HostPort struct {
 }
 
 type Config struct {
-	FileStoragePath string
-	DBAddress       string
-	HostPort        HostPort
-	StoreInterval   int
-	Restore         bool
+	FileStoragePath   string
+	DBAddress         string
+	GRPCAddress       string
+	Key               string
+	AdminToken        string
+	CryptoKey         string
+	TLSCert           string
+	TLSKey            string
+	TLSClientCA       string
+	HostPort          HostPort
+	TrustedSubnet     Subnet
+	ReadSubnet        Subnet
+	StoreInterval     int
+	HistorySize       int
+	AgentStaleTimeout int
+	IdempotencyTTL    int
+	MigrateDown       int
+	Restore           bool
+	MigrateOnly       bool
 }
 
 func (hp *HostPort) String() string {
@@ -49,8 +56,25 @@ func (conf *Config) String() string {
 			"FileStoragePath: %s\n"+
 			"Restore: %t\n"+
 			"Host: %s:%d\n"+
-			"DBAddress:%s",
-		conf.StoreInterval, conf.FileStoragePath, conf.Restore, conf.HostPort.Host, conf.HostPort.Port, conf.DBAddress)
+			"DBAddress:%s\n"+
+			"MigrateOnly: %t\n"+
+			"MigrateDown: %d\n"+
+			"HistorySize: %d\n"+
+			"AgentStaleTimeout: %d sec\n"+
+			"IdempotencyTTL: %d sec\n"+
+			"SignKeySet: %t\n"+
+			"AdminTokenSet: %t\n"+
+			"CryptoKey: %s\n"+
+			"TLSCert: %s\n"+
+			"TLSKey: %s\n"+
+			"TLSClientCA: %s\n"+
+			"TrustedSubnet: %s\n"+
+			"ReadSubnet: %s\n"+
+			"GRPCAddress: %s",
+		conf.StoreInterval, conf.FileStoragePath, conf.Restore, conf.HostPort.Host, conf.HostPort.Port, conf.DBAddress,
+		conf.MigrateOnly, conf.MigrateDown, conf.HistorySize, conf.AgentStaleTimeout, conf.IdempotencyTTL,
+		conf.Key != "", conf.AdminToken != "", conf.CryptoKey, conf.TLSCert, conf.TLSKey, conf.TLSClientCA,
+		conf.TrustedSubnet.String(), conf.ReadSubnet.String(), conf.GRPCAddress)
 }
 
 func (hp *HostPort) Set(value string) error {
@@ -77,6 +101,25 @@ func parseFlag() *Config {
 	flag.StringVar(&config.FileStoragePath, "f", "", "File path for store metrics")
 	flag.BoolVar(&config.Restore, "r", true, "Define should or not load store data from file before start")
 	flag.StringVar(&config.DBAddress, "d", "", "Address of connecting to Data Base")
+	flag.IntVar(&config.HistorySize, "history-size", defaultHistorySize,
+		"Number of samples kept for every metric")
+	flag.IntVar(&config.AgentStaleTimeout, "agent-stale-timeout", defaultAgentStaleTimeout,
+		"Seconds without reports after which agent is considered stale")
+	flag.IntVar(&config.IdempotencyTTL, "idempotency-ttl", defaultIdempotencyTTL,
+		"Seconds for which response to request with Idempotency-Key is remembered, 0 disables it")
+	flag.StringVar(&config.Key, "k", "", "Key for HMAC-SHA256 signing of request and response bodies")
+	flag.StringVar(&config.AdminToken, "admin-token", "",
+		"Bearer token of admin requests which delete and reset metrics, they are disabled if empty")
+	flag.StringVar(&config.CryptoKey, "crypto-key", "", "Path to PEM file with RSA private key for decrypting requests")
+	flag.StringVar(&config.TLSCert, "tls-cert", "", "Path to PEM file with TLS certificate, enables HTTPS")
+	flag.StringVar(&config.TLSKey, "tls-key", "", "Path to PEM file with private key of TLS certificate")
+	flag.StringVar(&config.TLSClientCA, "tls-client-ca", "",
+		"Path to CA bundle of client certificates, makes client certificate required")
+	flag.Var(&config.TrustedSubnet, "t", "CIDR of agents allowed to write metrics, any address by default")
+	flag.Var(&config.ReadSubnet, "read-trusted-subnet", "CIDR of clients allowed to read metrics, any address by default")
+	flag.StringVar(&config.GRPCAddress, "grpc-address", "", "Net address host:port of gRPC server, disabled by default")
+	flag.BoolVar(&config.MigrateOnly, "migrate-only", false, "Apply Data Base migrations and exit")
+	flag.IntVar(&config.MigrateDown, "migrate-down", 0, "Roll back given number of Data Base migrations and exit")
 	flag.Parse()
 	if config.HostPort.Host == "" && config.HostPort.Port == 0 {
 		config.HostPort.Host = "localhost"
@@ -126,10 +169,101 @@ func GetConfig() (*Config, error) {
 		config.DBAddress = dBAddress
 	}
 
+	historySize, ok := os.LookupEnv("HISTORY_SIZE")
+	if ok {
+		size, err := strconv.Atoi(historySize)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"environment variable HISTORY_SIZE must be numerical, got %s: %w", historySize, err)
+		}
+		config.HistorySize = size
+	}
+
+	staleTimeout, ok := os.LookupEnv("AGENT_STALE_TIMEOUT")
+	if ok {
+		timeout, err := strconv.Atoi(staleTimeout)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"environment variable AGENT_STALE_TIMEOUT must be numerical, got %s: %w", staleTimeout, err)
+		}
+		config.AgentStaleTimeout = timeout
+	}
+
+	idempotencyTTL, ok := os.LookupEnv("IDEMPOTENCY_TTL")
+	if ok {
+		ttl, err := strconv.Atoi(idempotencyTTL)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"environment variable IDEMPOTENCY_TTL must be numerical, got %s: %w", idempotencyTTL, err)
+		}
+		config.IdempotencyTTL = ttl
+	}
+
+	key, ok := os.LookupEnv("KEY")
+	if ok {
+		config.Key = key
+	}
+
+	adminToken, ok := os.LookupEnv("ADMIN_TOKEN")
+	if ok {
+		config.AdminToken = adminToken
+	}
+
+	cryptoKey, ok := os.LookupEnv("CRYPTO_KEY")
+	if ok {
+		config.CryptoKey = cryptoKey
+	}
+
+	tlsCert, ok := os.LookupEnv("TLS_CERT")
+	if ok {
+		config.TLSCert = tlsCert
+	}
+
+	tlsKey, ok := os.LookupEnv("TLS_KEY")
+	if ok {
+		config.TLSKey = tlsKey
+	}
+
+	tlsClientCA, ok := os.LookupEnv("TLS_CLIENT_CA")
+	if ok {
+		config.TLSClientCA = tlsClientCA
+	}
+
+	trustedSubnet, ok := os.LookupEnv("TRUSTED_SUBNET")
+	if ok {
+		err := config.TrustedSubnet.Set(trustedSubnet)
+		if err != nil {
+			return nil, fmt.Errorf("environment variable TRUSTED_SUBNET %w", err)
+		}
+	}
+
+	readSubnet, ok := os.LookupEnv("READ_TRUSTED_SUBNET")
+	if ok {
+		err := config.ReadSubnet.Set(readSubnet)
+		if err != nil {
+			return nil, fmt.Errorf("environment variable READ_TRUSTED_SUBNET %w", err)
+		}
+	}
+
+	grpcAddress, ok := os.LookupEnv("GRPC_ADDRESS")
+	if ok {
+		config.GRPCAddress = grpcAddress
+	}
+
+	migrateOnly, ok := os.LookupEnv("MIGRATE_ONLY")
+	if ok {
+		migrateOnlyBool, err := strconv.ParseBool(migrateOnly)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"environment variable MIGRATE_ONLY must be bool, got %s: %w", migrateOnly, err)
+		}
+		config.MigrateOnly = migrateOnlyBool
+	}
+
 	return config, nil
 }
 
-func createMetricDB(connectInfo string) (*sql.DB, error) {
+func openMetricDB(connectInfo string) (*sql.DB, error) {
 	db, err := sql.Open("pgx", connectInfo)
 	if err != nil {
 		return nil, fmt.Errorf("error in create Metric DB: %w", err)
@@ -139,32 +273,47 @@ func createMetricDB(connectInfo string) (*sql.DB, error) {
 	if err != nil {
 		return nil, fmt.Errorf("DB is unreachable: %w", err)
 	}
+	return db, nil
+}
 
-	ctx := context.Background()
-	_, err = db.ExecContext(ctx, sqlCreateGaugeTableCmd)
+func createMetricDB(connectInfo string) (*sql.DB, error) {
+	db, err := openMetricDB(connectInfo)
 	if err != nil {
-		return nil, fmt.Errorf("error in create gauges table: %w", err)
+		return nil, err
 	}
-	_, err = db.ExecContext(ctx, sqlCreateCounterTableCmd)
+
+	err = migrations.Up(context.Background(), db)
 	if err != nil {
-		return nil, fmt.Errorf("error in create counters table: %w", err)
+		return nil, fmt.Errorf("error in migrate Metric DB: %w", err)
 	}
 
 	return db, nil
 }
 
-func GetSyncInfo(conf Config) (*types.SyncInfo, error) {
-	var db *sql.DB
-	var err error
-	if conf.DBAddress != "" {
-		db, err = createMetricDB(conf.DBAddress)
-		if err != nil {
-			return nil, fmt.Errorf("error in create Metric Data Base: %w", err)
+// runMigrations serves -migrate-only and -migrate-down modes, when server only
+// changes schema of Data Base and exits.
+func runMigrations(conf *Config) (err error) {
+	if conf.DBAddress == "" {
+		return errors.New("migrations require Data Base address, set -d or DATABASE_DSN")
+	}
+	db, err := openMetricDB(conf.DBAddress)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := db.Close()
+		if closeErr != nil && err == nil {
+			err = fmt.Errorf("error in close connection with DB: %w", closeErr)
 		}
+	}()
+
+	if conf.MigrateDown > 0 {
+		err = migrations.Down(context.Background(), db, conf.MigrateDown)
+	} else {
+		err = migrations.Up(context.Background(), db)
+	}
+	if err != nil {
+		return fmt.Errorf("error in migrate Metric DB: %w", err)
 	}
-	return &types.SyncInfo{
-		DB:                db,
-		FileMetricStorage: conf.FileStoragePath,
-		SyncFileRecord:    conf.StoreInterval == 0,
-	}, nil
+	return nil
 }
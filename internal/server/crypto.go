@@ -0,0 +1,46 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/xChygyNx/metrical/internal/server/types"
+)
+
+// decryptHandler decrypts request bodies encrypted by agent with public key
+// of server. It must run before GzipHandler, because agent encrypts
+// compressed body.
+func decryptHandler(key *rsa.PrivateKey) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			scheme := req.Header.Get(types.EncryptionHeader)
+			if scheme == "" {
+				next.ServeHTTP(res, req)
+				return
+			}
+			if key == nil {
+				writeError(res, "server has no key to decrypt request", http.StatusBadRequest)
+				return
+			}
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				log.Printf("error in read request body: %v\n", err)
+				writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
+				return
+			}
+			plain, err := types.Decrypt(key, scheme, body)
+			if err != nil {
+				log.Println(err)
+				writeError(res, "can not decrypt request body", http.StatusBadRequest)
+				return
+			}
+			req.Body = io.NopCloser(bytes.NewReader(plain))
+			req.ContentLength = int64(len(plain))
+			req.Header.Del(types.EncryptionHeader)
+			next.ServeHTTP(res, req)
+		})
+	}
+}
@@ -1,10 +1,12 @@
 package server
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
+	"log"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/xChygyNx/metrical/internal/server/types"
@@ -14,30 +16,155 @@ const (
 	filePem = 0o600
 )
 
-func fileDump(fileName string, period time.Duration, storage *types.MemStorage) (err error) {
+type fileStorage struct {
+	*memStorage
+	done       chan struct{}
+	path       string
+	dumpWG     sync.WaitGroup
+	writeMu    sync.Mutex
+	syncRecord bool
+}
+
+func newFileStorage(mem *memStorage, path string, period time.Duration) *fileStorage {
+	fst := &fileStorage{
+		memStorage: mem,
+		path:       path,
+		syncRecord: period == 0,
+		done:       make(chan struct{}),
+	}
+	if !fst.syncRecord {
+		fst.dumpWG.Add(1)
+		go func() {
+			defer fst.dumpWG.Done()
+			err := fst.dump(period)
+			if err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+	return fst
+}
+
+// write serializes writers of the storage file, otherwise concurrent
+// handlers could interleave their content.
+func (fst *fileStorage) write() error {
+	fst.writeMu.Lock()
+	defer fst.writeMu.Unlock()
+	err := retryFileWrite(fst.path, fst.storage, retryFileWriteCount)
+	if err != nil {
+		return fmt.Errorf("failed to write metrics in file: %w", err)
+	}
+	return nil
+}
+
+func (fst *fileStorage) persist() error {
+	if !fst.syncRecord {
+		return nil
+	}
+	return fst.write()
+}
+
+func (fst *fileStorage) UpdateGauge(ctx context.Context, name string, labels map[string]string,
+	value float64) (float64, error) {
+	saved, err := fst.memStorage.UpdateGauge(ctx, name, labels, value)
+	if err != nil {
+		return 0, err
+	}
+	return saved, fst.persist()
+}
+
+func (fst *fileStorage) AddCounter(ctx context.Context, name string, labels map[string]string,
+	delta int64) (int64, error) {
+	saved, err := fst.memStorage.AddCounter(ctx, name, labels, delta)
+	if err != nil {
+		return 0, err
+	}
+	return saved, fst.persist()
+}
+
+func (fst *fileStorage) AddHistogram(ctx context.Context, name string, labels map[string]string,
+	histogram types.Histogram) (types.Histogram, error) {
+	saved, err := fst.memStorage.AddHistogram(ctx, name, labels, histogram)
+	if err != nil {
+		return saved, err
+	}
+	return saved, fst.persist()
+}
+
+func (fst *fileStorage) UpdateSummary(ctx context.Context, name string, labels map[string]string,
+	summary types.Summary) (types.Summary, error) {
+	saved, err := fst.memStorage.UpdateSummary(ctx, name, labels, summary)
+	if err != nil {
+		return saved, err
+	}
+	return saved, fst.persist()
+}
+
+func (fst *fileStorage) UpdateBatch(ctx context.Context, metrics []types.Metrics) ([]types.Metrics, error) {
+	saved, err := fst.memStorage.UpdateBatch(ctx, metrics)
+	if err != nil {
+		return nil, err
+	}
+	return saved, fst.persist()
+}
+
+func (fst *fileStorage) Delete(ctx context.Context, mType, name string, labels map[string]string) error {
+	err := fst.memStorage.Delete(ctx, mType, name, labels)
+	if err != nil {
+		return err
+	}
+	return fst.persist()
+}
+
+func (fst *fileStorage) DeleteMatching(ctx context.Context, mType, pattern string,
+	filter map[string]string) ([]types.Metrics, error) {
+	deleted, err := fst.memStorage.DeleteMatching(ctx, mType, pattern, filter)
+	if err != nil || len(deleted) == 0 {
+		return deleted, err
+	}
+	return deleted, fst.persist()
+}
+
+func (fst *fileStorage) ResetCounter(ctx context.Context, name string, labels map[string]string) error {
+	err := fst.memStorage.ResetCounter(ctx, name, labels)
 	if err != nil {
-		return
+		return err
 	}
+	return fst.persist()
+}
+
+// Close stops periodic dump and flushes metrics received since the last one.
+func (fst *fileStorage) Close() error {
+	close(fst.done)
+	fst.dumpWG.Wait()
+	return fst.write()
+}
+
+func (fst *fileStorage) dump(period time.Duration) error {
 	ticker := time.NewTicker(period)
 	defer ticker.Stop()
-	for range ticker.C {
-		err := writeMetricStorageFile(fileName, storage)
-		if err != nil {
-			return fmt.Errorf("error in write data in metric storage file: %w", err)
+	for {
+		select {
+		case <-fst.done:
+			return nil
+		case <-ticker.C:
+			err := fst.write()
+			if err != nil {
+				return fmt.Errorf("error in write data in metric storage file: %w", err)
+			}
 		}
 	}
-	return
 }
 
 func writeMetricStorageFile(absStorageFilePath string, storage *types.MemStorage) (err error) {
-	file, err := os.OpenFile(absStorageFilePath, os.O_WRONLY|os.O_CREATE, filePem)
+	file, err := os.OpenFile(absStorageFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, filePem)
 	if err != nil {
 		return fmt.Errorf("error in open file %s: %w", absStorageFilePath, err)
 	}
 	defer func() {
 		err = file.Close()
 	}()
-	data, err := json.Marshal(*storage)
+	data, err := json.Marshal(storage.Snapshot())
 	if err != nil {
 		return fmt.Errorf("error in marshal data for record in fille: %w", err)
 	}
@@ -51,7 +178,7 @@ func writeMetricStorageFile(absStorageFilePath string, storage *types.MemStorage
 func retryFileWrite(absStorageFilePath string, storage *types.MemStorage, retryCount int) (err error) {
 	delays := make([]time.Duration, 0, retryCount)
 	delays = append(delays, 0*time.Second)
-	for i := 1; i < retryCount-1; i++ {
+	for i := 1; i < retryCount; i++ {
 		delays = append(delays, time.Duration(2*i-1)*time.Second)
 	}
 
@@ -65,25 +192,19 @@ func retryFileWrite(absStorageFilePath string, storage *types.MemStorage, retryC
 	return
 }
 
-func restoreMetricStore(fileName string, storage *types.MemStorage) (err error) {
-	file, err := os.OpenFile(fileName, os.O_RDONLY, filePem)
+// restoreMetricStore loads snapshot saved by writeMetricStorageFile. Snapshot
+// is one JSON document of any size.
+func restoreMetricStore(fileName string, storage *types.MemStorage) error {
+	data, err := os.ReadFile(fileName)
 	if err != nil {
-		return
+		return fmt.Errorf("error in read metric storage file: %w", err)
 	}
-	defer func() {
-		err = file.Close()
-	}()
-
-	sc := bufio.NewScanner(file)
-	if !sc.Scan() {
-		return fmt.Errorf("error scan metric storage file: %w", sc.Err())
-	}
-	data := sc.Bytes()
 
-	err = json.Unmarshal(data, storage)
+	var snapshot types.Snapshot
+	err = json.Unmarshal(data, &snapshot)
 	if err != nil {
-		return
+		return fmt.Errorf("error in parse metric storage file %s: %w", fileName, err)
 	}
-
-	return
+	storage.Restore(snapshot)
+	return nil
 }
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	_ "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/xChygyNx/metrical/internal/proto"
+	"github.com/xChygyNx/metrical/internal/server/types"
+)
+
+// grpcWriteMethods change storage, other methods only read it. Values make
+// empty responses of methods to decode remembered ones.
+var grpcWriteMethods = map[string]func() proto.Message{
+	pb.Metrics_UpdateMetric_FullMethodName:  func() proto.Message { return &pb.UpdateMetricResponse{} },
+	pb.Metrics_UpdateMetrics_FullMethodName: func() proto.Message { return &pb.UpdateMetricsResponse{} },
+}
+
+func firstValue(md metadata.MD, key string) string {
+	values := md.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// grpcInterceptor applies to gRPC calls the same trusted subnets, signing
+// and agent identification as HTTP middlewares do.
+func grpcInterceptor(config *Config, agents *types.AgentRegistry) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		var remoteAddr string
+		var tlsInfo credentials.TLSInfo
+		if p, ok := peer.FromContext(ctx); ok {
+			remoteAddr = p.Addr.String()
+			tlsInfo, _ = p.AuthInfo.(credentials.TLSInfo)
+		}
+
+		_, isWrite := grpcWriteMethods[info.FullMethod]
+		subnet := config.ReadSubnet
+		if isWrite {
+			subnet = config.TrustedSubnet
+		}
+		if !subnet.allows(clientIP(firstValue(md, pb.RealIPKey), remoteAddr)) {
+			return nil, status.Error(codes.PermissionDenied, untrustedIPMsg)
+		}
+
+		if isWrite && config.Key != "" {
+			msg, ok := req.(proto.Message)
+			if !ok || !pb.CheckMessageSign(msg, config.Key, firstValue(md, pb.HashKey)) {
+				return nil, status.Error(codes.Unauthenticated, badSignMsg)
+			}
+		}
+
+		agentID := firstValue(md, pb.AgentIDKey)
+		if len(tlsInfo.State.VerifiedChains) > 0 {
+			if commonName := tlsInfo.State.VerifiedChains[0][0].Subject.CommonName; commonName != "" {
+				agentID = commonName
+			}
+		}
+		if len(agentID) > maxAgentIDSize {
+			return nil, status.Errorf(codes.InvalidArgument, "%s must be at most %d bytes", pb.AgentIDKey, maxAgentIDSize)
+		}
+		if isWrite && agentID != "" {
+			agents.Seen(agentID, remoteAddr, time.Now())
+			ctx = context.WithValue(ctx, agentIDKey{}, agentID)
+		}
+
+		resp, err := handler(ctx, req)
+		if err != nil || config.Key == "" {
+			return resp, err
+		}
+		msg, ok := resp.(proto.Message)
+		if !ok {
+			return resp, nil
+		}
+		sign, err := pb.SignMessage(msg, config.Key)
+		if err != nil {
+			return nil, status.Error(codes.Internal, internalServerErrorMsg)
+		}
+		err = grpc.SetHeader(ctx, metadata.Pairs(pb.HashKey, sign))
+		if err != nil {
+			return nil, status.Error(codes.Internal, internalServerErrorMsg)
+		}
+		return resp, nil
+	}
+}
+
+func replayGRPCResponse(ctx context.Context, response storedResponse, requestHash string,
+	newResponse func() proto.Message) (interface{}, error) {
+	if response.RequestHash != requestHash {
+		return nil, status.Error(codes.InvalidArgument, keyReusedMsg)
+	}
+	msg := newResponse()
+	err := proto.Unmarshal(response.Body, msg)
+	if err != nil {
+		log.Printf("error in decode remembered gRPC response: %v\n", err)
+		return nil, status.Error(codes.Internal, internalServerErrorMsg)
+	}
+	err = grpc.SetHeader(ctx, metadata.Pairs(pb.ReplayedKey, "true"))
+	if err != nil {
+		return nil, status.Error(codes.Internal, internalServerErrorMsg)
+	}
+	return msg, nil
+}
+
+// grpcIdempotent answers write call with idempotency key in metadata by
+// response remembered for the key, like idempotent does for HTTP requests.
+// Failed calls are not remembered, so they can be retried.
+func grpcIdempotent(keys *idempotencyKeys) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		key := firstValue(md, pb.IdempotencyKey)
+		newResponse, isWrite := grpcWriteMethods[info.FullMethod]
+		msg, ok := req.(proto.Message)
+		if keys == nil || !isWrite || key == "" || !ok {
+			return handler(ctx, req)
+		}
+		if len(key) > maxIdempotencyKeySize {
+			return nil, status.Errorf(codes.InvalidArgument, "%s must be at most %d bytes",
+				pb.IdempotencyKey, maxIdempotencyKeySize)
+		}
+		data, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		hash := sha256.New()
+		hash.Write([]byte(info.FullMethod + "\n"))
+		hash.Write(data)
+		requestHash := hex.EncodeToString(hash.Sum(nil))
+
+		entry, owner := keys.begin(key, time.Now())
+		for !owner {
+			select {
+			case <-entry.done:
+			case <-ctx.Done():
+				return nil, status.FromContextError(ctx.Err()).Err()
+			}
+			if entry.saved {
+				return replayGRPCResponse(ctx, entry.response, requestHash, newResponse)
+			}
+			entry, owner = keys.begin(key, time.Now())
+		}
+
+		// Key is forgotten unless response is remembered, even if handler panics.
+		var remembered *storedResponse
+		reserved := false
+		defer func() {
+			if reserved {
+				keys.complete(ctx, key, remembered)
+			}
+			keys.finish(key, entry, remembered, time.Now())
+		}()
+		if keys.store != nil {
+			stored, err := keys.reserve(ctx, key, requestHash)
+			if err != nil {
+				// Without reserved key call could be applied twice.
+				log.Println(err)
+				return nil, status.Error(codes.Internal, internalServerErrorMsg)
+			}
+			if stored != nil {
+				remembered = stored
+				return replayGRPCResponse(ctx, *stored, requestHash, newResponse)
+			}
+			reserved = true
+		}
+
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return resp, err
+		}
+		respMsg, ok := resp.(proto.Message)
+		if !ok {
+			return resp, nil
+		}
+		body, err := proto.Marshal(respMsg)
+		if err != nil {
+			log.Printf("error in encode gRPC response to remember: %v\n", err)
+			return resp, nil
+		}
+		remembered = &storedResponse{RequestHash: requestHash, Body: body, Status: int(codes.OK)}
+		return resp, nil
+	}
+}
+
+// newGRPCServer returns gRPC server of metrics storage. Compressed calls are
+// accepted, because gzip codec is registered by import.
+func newGRPCServer(config *Config, storage Storage, agents *types.AgentRegistry,
+	tlsConfig *tls.Config) *grpc.Server {
+	keys := newIdempotencyKeys(time.Duration(config.IdempotencyTTL)*time.Second, storage)
+	options := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(grpcInterceptor(config, agents), grpcIdempotent(keys)),
+	}
+	if tlsConfig != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	server := grpc.NewServer(options...)
+	pb.RegisterMetricsServer(server, &metricsServer{storage: storage})
+	return server
+}
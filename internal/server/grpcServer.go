@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/xChygyNx/metrical/internal/proto"
+	"github.com/xChygyNx/metrical/internal/server/types"
+)
+
+// metricsServer serves gRPC API over the same storage as HTTP handlers.
+type metricsServer struct {
+	pb.UnimplementedMetricsServer
+	storage Storage
+}
+
+func mTypeFromProto(mType pb.Metric_MType) (string, error) {
+	switch mType {
+	case pb.Metric_GAUGE:
+		return GAUGE, nil
+	case pb.Metric_COUNTER:
+		return COUNTER, nil
+	default:
+		return "", status.Errorf(codes.InvalidArgument, "%v, got %s", ErrUnknownType, mType)
+	}
+}
+
+func metricFromProto(ctx context.Context, metric *pb.Metric) (types.Metrics, error) {
+	if metric == nil {
+		return types.Metrics{}, status.Error(codes.InvalidArgument, "metric is required")
+	}
+	mType, err := mTypeFromProto(metric.GetType())
+	if err != nil {
+		return types.Metrics{}, err
+	}
+	result := types.Metrics{
+		ID:     metric.GetId(),
+		MType:  mType,
+		Labels: metric.GetLabels(),
+	}
+	if mType == GAUGE {
+		value := metric.GetValue()
+		result.Value = &value
+	} else {
+		delta := metric.GetDelta()
+		result.Delta = &delta
+	}
+	err = result.Validate()
+	if err != nil {
+		return types.Metrics{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	result.Labels, err = withAgentLabel(ctx, result.Labels)
+	if err != nil {
+		return types.Metrics{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return result, nil
+}
+
+func metricToProto(metric types.Metrics) *pb.Metric {
+	result := &pb.Metric{Id: metric.ID, Labels: metric.Labels}
+	switch metric.MType {
+	case GAUGE:
+		result.Type = pb.Metric_GAUGE
+	case COUNTER:
+		result.Type = pb.Metric_COUNTER
+	}
+	if metric.Value != nil {
+		result.Value = *metric.Value
+	}
+	if metric.Delta != nil {
+		result.Delta = *metric.Delta
+	}
+	return result
+}
+
+func storageStatus(err error) error {
+	switch {
+	case errors.Is(err, ErrMetricNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrUnknownType):
+		return status.Error(codes.InvalidArgument, err.Error())
+	default:
+		log.Println(err)
+		return status.Error(codes.Internal, internalServerErrorMsg)
+	}
+}
+
+func (ms *metricsServer) UpdateMetric(ctx context.Context, req *pb.UpdateMetricRequest) (*pb.UpdateMetricResponse,
+	error) {
+	metric, err := metricFromProto(ctx, req.GetMetric())
+	if err != nil {
+		return nil, err
+	}
+	if metric.MType == GAUGE {
+		value, err := ms.storage.UpdateGauge(ctx, metric.ID, metric.Labels, *metric.Value)
+		if err != nil {
+			return nil, storageStatus(err)
+		}
+		metric.Value = &value
+	} else {
+		delta, err := ms.storage.AddCounter(ctx, metric.ID, metric.Labels, *metric.Delta)
+		if err != nil {
+			return nil, storageStatus(err)
+		}
+		metric.Delta = &delta
+	}
+	return &pb.UpdateMetricResponse{Metric: metricToProto(metric)}, nil
+}
+
+func (ms *metricsServer) UpdateMetrics(ctx context.Context, req *pb.UpdateMetricsRequest) (*pb.UpdateMetricsResponse,
+	error) {
+	metrics := make([]types.Metrics, 0, len(req.GetMetrics()))
+	for _, item := range req.GetMetrics() {
+		metric, err := metricFromProto(ctx, item)
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, metric)
+	}
+	updated, err := ms.storage.UpdateBatch(ctx, metrics)
+	if err != nil {
+		return nil, storageStatus(err)
+	}
+	response := &pb.UpdateMetricsResponse{Metrics: make([]*pb.Metric, 0, len(updated))}
+	for _, metric := range updated {
+		response.Metrics = append(response.Metrics, metricToProto(metric))
+	}
+	return response, nil
+}
+
+func (ms *metricsServer) GetMetric(ctx context.Context, req *pb.GetMetricRequest) (*pb.GetMetricResponse, error) {
+	mType, err := mTypeFromProto(req.GetType())
+	if err != nil {
+		return nil, err
+	}
+	metric, err := ms.storage.Get(ctx, mType, req.GetId(), req.GetLabels())
+	if err != nil {
+		return nil, storageStatus(err)
+	}
+	return &pb.GetMetricResponse{Metric: metricToProto(metric)}, nil
+}
+
+func (ms *metricsServer) ListMetrics(ctx context.Context, req *pb.ListMetricsRequest) (*pb.ListMetricsResponse, error) {
+	metrics, err := ms.storage.List(ctx, req.GetLabels())
+	if err != nil {
+		return nil, storageStatus(err)
+	}
+	response := &pb.ListMetricsResponse{Metrics: make([]*pb.Metric, 0, len(metrics))}
+	for _, metric := range metrics {
+		// gRPC API has only gauges and counters.
+		if metric.MType != GAUGE && metric.MType != COUNTER {
+			continue
+		}
+		response.Metrics = append(response.Metrics, metricToProto(metric))
+	}
+	return response, nil
+}
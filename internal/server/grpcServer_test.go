@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	pb "github.com/xChygyNx/metrical/internal/proto"
+	"github.com/xChygyNx/metrical/internal/server/types"
+)
+
+func newTestGRPCClient(t *testing.T, config *Config, storage Storage) pb.MetricsClient {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	server := newGRPCServer(config, storage, types.NewAgentRegistry(), nil)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.UseCompressor(gzip.Name)))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return pb.NewMetricsClient(conn)
+}
+
+func TestGRPCMetrics(t *testing.T) {
+	storage := newMemStorage(defaultHistorySize)
+	client := newTestGRPCClient(t, &Config{}, storage)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), pb.AgentIDKey, "host1")
+
+	updated, err := client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+		{Id: "Alloc", Type: pb.Metric_GAUGE, Value: 1.5},
+		{Id: "PollCount", Type: pb.Metric_COUNTER, Delta: 3},
+	}})
+	require.NoError(t, err)
+	require.Len(t, updated.GetMetrics(), 2)
+
+	counter, err := client.UpdateMetric(ctx, &pb.UpdateMetricRequest{
+		Metric: &pb.Metric{Id: "PollCount", Type: pb.Metric_COUNTER, Delta: 2},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), counter.GetMetric().GetDelta())
+
+	agentLabels := map[string]string{agentLabel: "host1"}
+	gauge, err := client.GetMetric(context.Background(), &pb.GetMetricRequest{
+		Id: "Alloc", Type: pb.Metric_GAUGE, Labels: agentLabels,
+	})
+	require.NoError(t, err)
+	assert.InDelta(t, 1.5, gauge.GetMetric().GetValue(), 0)
+
+	list, err := client.ListMetrics(context.Background(), &pb.ListMetricsRequest{Labels: agentLabels})
+	require.NoError(t, err)
+	assert.Len(t, list.GetMetrics(), 2)
+
+	_, err = client.GetMetric(context.Background(), &pb.GetMetricRequest{Id: "Alloc", Type: pb.Metric_GAUGE})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.UpdateMetric(ctx, &pb.UpdateMetricRequest{Metric: &pb.Metric{Id: "Alloc"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPCInterceptor(t *testing.T) {
+	const key = "secret"
+	config := &Config{Key: key}
+	require.NoError(t, config.TrustedSubnet.Set("10.0.0.0/8"))
+	client := newTestGRPCClient(t, config, newMemStorage(defaultHistorySize))
+	req := &pb.UpdateMetricRequest{Metric: &pb.Metric{Id: "Alloc", Type: pb.Metric_GAUGE, Value: 1}}
+	sign, err := pb.SignMessage(req, key)
+	require.NoError(t, err)
+	otherSign, err := pb.SignMessage(req, "other")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		realIP string
+		sign   string
+		code   codes.Code
+	}{
+		{
+			name:   "Signed call from trusted subnet",
+			realIP: "10.0.0.1",
+			sign:   sign,
+			code:   codes.OK,
+		},
+		{
+			name:   "Call from outside",
+			realIP: "192.168.0.1",
+			sign:   sign,
+			code:   codes.PermissionDenied,
+		},
+		{
+			name:   "Signature by other key",
+			realIP: "10.0.0.1",
+			sign:   otherSign,
+			code:   codes.Unauthenticated,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := metadata.AppendToOutgoingContext(context.Background(),
+				pb.RealIPKey, test.realIP, pb.HashKey, test.sign)
+			var header metadata.MD
+			resp, err := client.UpdateMetric(ctx, req, grpc.Header(&header))
+			assert.Equal(t, test.code, status.Code(err))
+			if test.code == codes.OK {
+				signs := header.Get(pb.HashKey)
+				require.Len(t, signs, 1)
+				assert.True(t, pb.CheckMessageSign(resp, key, signs[0]))
+			}
+		})
+	}
+}
+
+func TestGRPCIdempotentUpdates(t *testing.T) {
+	storage := newMemStorage(defaultHistorySize)
+	client := newTestGRPCClient(t, &Config{IdempotencyTTL: defaultIdempotencyTTL}, storage)
+	req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{{Id: "PollCount", Type: pb.Metric_COUNTER, Delta: 3}}}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), pb.IdempotencyKey, "report-1-0")
+
+	for range 2 {
+		updated, err := client.UpdateMetrics(ctx, req)
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), updated.GetMetrics()[0].GetDelta())
+	}
+	metric, err := storage.Get(context.Background(), COUNTER, "PollCount", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), *metric.Delta, "repeated call is not applied twice")
+
+	var header metadata.MD
+	_, err = client.UpdateMetrics(ctx, req, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"true"}, header.Get(pb.ReplayedKey))
+
+	other := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{{Id: "PollCount", Type: pb.Metric_COUNTER, Delta: 4}}}
+	_, err = client.UpdateMetrics(ctx, other)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "key can't be reused for other call")
+}
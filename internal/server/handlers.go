@@ -3,22 +3,27 @@ package server
 import (
 	"bytes"
 	"context"
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"strconv"
-	"time"
+	"strings"
 
-	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/xChygyNx/metrical/internal/server/types"
 )
 
 const (
-	GAUGE   = "gauge"
-	COUNTER = "counter"
+	GAUGE     = "gauge"
+	COUNTER   = "counter"
+	HISTOGRAM = "histogram"
+	SUMMARY   = "summary"
+
+	batchModeParam   = "mode"
+	batchModeAtomic  = "atomic"
+	batchModePartial = "partial"
 
 	contentType            = "Content-type"
 	countGaugeMetrics      = 28
@@ -41,7 +46,29 @@ func parseCounterMetricValue(value string) (num int64, err error) {
 	return
 }
 
-func saveMetricValue(mType, mName, value string, storage *types.MemStorage) (err error) {
+// parseLabelParams reads labels from repeated query parameters label=<name>=<value>.
+func parseLabelParams(req *http.Request) (map[string]string, error) {
+	params := req.URL.Query()["label"]
+	if len(params) == 0 {
+		return nil, nil
+	}
+	labels := make(map[string]string, len(params))
+	for _, param := range params {
+		name, value, ok := strings.Cut(param, "=")
+		if !ok {
+			return nil, &types.ValidationError{Field: "label", Message: "must be like <name>=<value>, got " + param}
+		}
+		labels[name] = value
+	}
+	err := types.ValidateLabels(labels)
+	if err != nil {
+		return nil, &types.ValidationError{Field: "label", Message: err.Error()}
+	}
+	return labels, nil
+}
+
+func saveMetricValue(ctx context.Context, mType, mName string, labels map[string]string, value string,
+	storage Storage) (err error) {
 	switch mType {
 	case GAUGE:
 		var num float64
@@ -49,45 +76,29 @@ func saveMetricValue(mType, mName, value string, storage *types.MemStorage) (err
 		if err != nil {
 			return
 		}
-		storage.SetGauge(mName, num)
+		err = types.ValidateValue(num)
+		if err != nil {
+			return
+		}
+		_, err = storage.UpdateGauge(ctx, mName, labels, num)
 	case COUNTER:
 		var num int64
 		num, err = parseCounterMetricValue(value)
 		if err != nil {
 			return
 		}
-		storage.SetCounter(mName, num)
+		_, err = storage.AddCounter(ctx, mName, labels, num)
 	}
 	return
 }
 
-func pingDBHandle(dBAddress string) http.HandlerFunc {
+func pingDBHandle(storage Storage) http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
-		db, err := sql.Open("pgx", dBAddress)
-		if err != nil {
-			errorMsg := fmt.Errorf("can't connect to DB videos: %w", err)
-			log.Println(errorMsg)
-			http.Error(res, internalServerErrorMsg, http.StatusInternalServerError)
-			return
-		}
-
-		defer func() {
-			err := db.Close()
-			if err != nil {
-				errorMsg := fmt.Errorf("can't close connection with DB videos: %w", err)
-				log.Println(errorMsg)
-				http.Error(res, internalServerErrorMsg, http.StatusInternalServerError)
-				return
-			}
-		}()
-
-		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-		defer cancel()
-		err = db.PingContext(ctx)
+		err := storage.Ping(req.Context())
 		if err != nil {
-			errorMsg := fmt.Errorf("can't connect to DB videos: %w", err)
+			errorMsg := fmt.Errorf("can't connect to DB: %w", err)
 			log.Println(errorMsg)
-			http.Error(res, internalServerErrorMsg, http.StatusInternalServerError)
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
 			return
 		}
 
@@ -95,34 +106,52 @@ func pingDBHandle(dBAddress string) http.HandlerFunc {
 	}
 }
 
-func SaveMetricHandleOld(storage *types.MemStorage, syncInfo *types.SyncInfo) http.HandlerFunc {
+func SaveMetricHandleOld(storage Storage) http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
 		res.Header().Set(contentType, textContentType)
 
 		metricType := req.PathValue("mType")
-		if metricType != GAUGE && metricType != COUNTER {
-			errorMsg := "Unknown metric type, must be gauge or counter, got " + metricType
-			http.Error(res, errorMsg, http.StatusBadRequest)
+		err := types.ValidateType(metricType)
+		if err != nil {
+			writeBadRequest(res, err)
+			return
+		}
+		if metricType == HISTOGRAM || metricType == SUMMARY {
+			writeFieldError(res, metricType+" can be updated only by JSON", "type", http.StatusBadRequest)
 			return
 		}
 
 		metricName := req.PathValue("metric")
+		err = types.ValidateName(metricName)
+		if err != nil {
+			writeBadRequest(res, err)
+			return
+		}
 		metricValue := req.PathValue("value")
+		labels, err := parseLabelParams(req)
+		if err != nil {
+			writeBadRequest(res, err)
+			return
+		}
 
-		err := saveMetricValue(metricType, metricName, metricValue, storage)
+		labels, err = withAgentLabel(req.Context(), labels)
 		if err != nil {
-			errorMsg := fmt.Sprintf("Value of metric must be numeric, got %s, err: %v\n", metricValue, err)
-			http.Error(res, errorMsg, http.StatusBadRequest)
+			writeBadRequest(res, err)
 			return
 		}
-		if syncInfo.SyncFileRecord {
-			err = retryFileWrite(syncInfo.FileMetricStorage, storage, retryFileWriteCount)
-			if err != nil {
-				errorMsg := fmt.Errorf("failed to write metrics in file: %w", err).Error()
-				log.Println(errorMsg)
-				http.Error(res, internalServerErrorMsg, http.StatusInternalServerError)
-				return
-			}
+		err = saveMetricValue(req.Context(), metricType, metricName, labels, metricValue, storage)
+		var numErr *strconv.NumError
+		if errors.As(err, &numErr) {
+			errorMsg := "must be numeric, got " + metricValue
+			writeFieldError(res, errorMsg, "value", http.StatusBadRequest)
+			return
+		} else if errors.Is(err, types.ErrInvalidMetric) {
+			writeBadRequest(res, err)
+			return
+		} else if err != nil {
+			log.Println(err)
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
+			return
 		}
 
 		res.WriteHeader(http.StatusOK)
@@ -130,13 +159,13 @@ func SaveMetricHandleOld(storage *types.MemStorage, syncInfo *types.SyncInfo) ht
 		if err != nil {
 			errorMsg := fmt.Errorf(errorMsgWildcard, writeHandlerErrorMsg, err).Error()
 			log.Println(errorMsg)
-			http.Error(res, internalServerErrorMsg, http.StatusInternalServerError)
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
 			return
 		}
 	}
 }
 
-func SaveMetricHandle(storage *types.MemStorage, syncInfo *types.SyncInfo) http.HandlerFunc {
+func SaveMetricHandle(storage Storage) http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
 		res.Header().Set(contentType, jsonContentType)
 
@@ -147,73 +176,91 @@ func SaveMetricHandle(storage *types.MemStorage, syncInfo *types.SyncInfo) http.
 		if err != nil {
 			errorMsg := "error in read response body: " + err.Error()
 			fmt.Println(errorMsg)
-			http.Error(res, internalServerErrorMsg, http.StatusInternalServerError)
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
 			return
 		}
 		var metricData types.Metrics
 
 		err = json.Unmarshal(bodyByte, &metricData)
-		metricName := metricData.ID
+		if err != nil {
+			writeError(res, "error in decode request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = metricData.Validate()
+		if err != nil {
+			writeBadRequest(res, err)
+			return
+		}
+		metricData.Labels, err = withAgentLabel(req.Context(), metricData.Labels)
+		if err != nil {
+			writeBadRequest(res, err)
+			return
+		}
 		var responseData types.Metrics
 		switch metricData.MType {
 		case GAUGE:
-			storage.SetGauge(metricName, *metricData.Value)
-			value, ok := storage.GetGauge(metricData.ID)
-			if !ok {
-				errorMsg := fmt.Sprintf("Value gauge metric %s don't saved", metricData.ID)
-				log.Println(errorMsg)
-				http.Error(res, internalServerErrorMsg, http.StatusInternalServerError)
+			value, err := storage.UpdateGauge(req.Context(), metricData.ID, metricData.Labels, *metricData.Value)
+			if err != nil {
+				log.Println(err)
+				writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
 				return
 			}
 			responseData = types.Metrics{
-				ID:    metricData.ID,
-				MType: metricData.MType,
-				Value: &value,
+				ID:     metricData.ID,
+				MType:  metricData.MType,
+				Labels: metricData.Labels,
+				Value:  &value,
 			}
 		case COUNTER:
-			storage.SetCounter(metricName, *metricData.Delta)
-			value, ok := storage.GetCounter(metricData.ID)
-			if !ok {
-				errorMsg := fmt.Sprintf("Value counter metric %s don't saved", metricData.ID)
-				log.Println(errorMsg)
-				http.Error(res, internalServerErrorMsg, http.StatusInternalServerError)
+			delta, err := storage.AddCounter(req.Context(), metricData.ID, metricData.Labels, *metricData.Delta)
+			if err != nil {
+				log.Println(err)
+				writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
 				return
 			}
 			responseData = types.Metrics{
-				ID:    metricData.ID,
-				MType: metricData.MType,
-				Delta: &value,
+				ID:     metricData.ID,
+				MType:  metricData.MType,
+				Labels: metricData.Labels,
+				Delta:  &delta,
 			}
-		default:
-			bodyStr := string(bodyByte)
-			errorMsg := "Unknown metric type, must be gauge or counter, got |" + metricData.MType +
-				"|\n" + bodyStr
-			http.Error(res, errorMsg, http.StatusBadRequest)
-			return
-		}
-
-		if syncInfo.DB != nil {
-			err = retryDBWrite(syncInfo.DB, storage, retryDBWriteCount)
-			if err != nil && err.Error() != "sql: transaction has already been committed or rolled back" {
-				errorMsg := fmt.Errorf("failed to write metrics in DB: %w", err).Error()
-				log.Println(errorMsg)
-				http.Error(res, internalServerErrorMsg, http.StatusInternalServerError)
+		case HISTOGRAM:
+			histogram, err := storage.AddHistogram(req.Context(), metricData.ID, metricData.Labels,
+				*metricData.Histogram)
+			if errors.Is(err, types.ErrBoundsMismatch) {
+				writeFieldError(res, types.ErrBoundsMismatch.Error(), "histogram.bounds", http.StatusConflict)
 				return
+			} else if err != nil {
+				log.Println(err)
+				writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
+				return
+			}
+			responseData = types.Metrics{
+				ID:        metricData.ID,
+				MType:     metricData.MType,
+				Labels:    metricData.Labels,
+				Histogram: &histogram,
 			}
-		} else if syncInfo.SyncFileRecord {
-			err = retryFileWrite(syncInfo.FileMetricStorage, storage, retryFileWriteCount)
+		case SUMMARY:
+			summary, err := storage.UpdateSummary(req.Context(), metricData.ID, metricData.Labels, *metricData.Summary)
 			if err != nil {
-				errorMsg := fmt.Errorf("failed to write metrics in file: %w", err).Error()
-				http.Error(res, errorMsg, http.StatusBadRequest)
+				log.Println(err)
+				writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
 				return
 			}
+			responseData = types.Metrics{
+				ID:      metricData.ID,
+				MType:   metricData.MType,
+				Labels:  metricData.Labels,
+				Summary: &summary,
+			}
 		}
 
 		encodedResponseData, err := json.Marshal(responseData)
 		if err != nil {
 			errorMsg := fmt.Errorf("error in serialize response for send by server: %w", err).Error()
 			log.Println(errorMsg)
-			http.Error(res, internalServerErrorMsg, http.StatusInternalServerError)
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
 			return
 		}
 
@@ -222,16 +269,108 @@ func SaveMetricHandle(storage *types.MemStorage, syncInfo *types.SyncInfo) http.
 		if err != nil {
 			errorMsg := fmt.Errorf(errorMsgWildcard, writeHandlerErrorMsg, err).Error()
 			log.Println(errorMsg)
-			http.Error(res, internalServerErrorMsg, http.StatusInternalServerError)
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
 			return
 		}
 	}
 }
 
-func SaveBatchMetricHandle(storage *types.MemStorage, syncInfo *types.SyncInfo) http.HandlerFunc {
+// validateBatch checks metrics of batch and namespaces valid ones by agent
+// label. Invalid metrics are reported in result and left out of returned ones.
+func validateBatch(ctx context.Context, metrics []types.Metrics) ([]types.Metrics, types.BatchResult) {
+	valid := make([]types.Metrics, 0, len(metrics))
+	result := types.BatchResult{Results: make([]types.BatchItemResult, 0, len(metrics))}
+	for i, metric := range metrics {
+		item := types.BatchItemResult{ID: metric.ID, Index: i, Accepted: true}
+		err := metric.Validate()
+		if err == nil {
+			metric.Labels, err = withAgentLabel(ctx, metric.Labels)
+		}
+		var validationErr *types.ValidationError
+		if errors.As(err, &validationErr) {
+			item.Accepted = false
+			item.Error = &types.ErrorResponse{
+				Code:    http.StatusBadRequest,
+				Message: validationErr.Message,
+				Field:   validationErr.Field,
+			}
+			result.Rejected++
+		} else {
+			valid = append(valid, metric)
+			result.Accepted++
+		}
+		result.Results = append(result.Results, item)
+	}
+	return valid, result
+}
+
+// savePartialBatch saves valid metrics of partial batch. Histograms are saved
+// one by one, so histogram whose bounds differ from saved ones is rejected in
+// result instead of the whole batch.
+func savePartialBatch(ctx context.Context, storage Storage, valid []types.Metrics,
+	result *types.BatchResult) error {
+	type indexedHistogram struct {
+		metric types.Metrics
+		index  int
+	}
+	others := make([]types.Metrics, 0, len(valid))
+	histograms := make([]indexedHistogram, 0)
+	next := 0
+	for i, item := range result.Results {
+		if !item.Accepted {
+			continue
+		}
+		metric := valid[next]
+		next++
+		if metric.MType == HISTOGRAM {
+			histograms = append(histograms, indexedHistogram{metric: metric, index: i})
+		} else {
+			others = append(others, metric)
+		}
+	}
+
+	if len(others) > 0 {
+		_, err := storage.UpdateBatch(ctx, others)
+		if err != nil {
+			return fmt.Errorf("error in save batch: %w", err)
+		}
+	}
+	for _, histogram := range histograms {
+		_, err := storage.AddHistogram(ctx, histogram.metric.ID, histogram.metric.Labels, *histogram.metric.Histogram)
+		if errors.Is(err, types.ErrBoundsMismatch) {
+			item := &result.Results[histogram.index]
+			item.Accepted = false
+			item.Error = &types.ErrorResponse{
+				Code:    http.StatusConflict,
+				Message: types.ErrBoundsMismatch.Error(),
+				Field:   "histogram.bounds",
+			}
+			result.Accepted--
+			result.Rejected++
+		} else if err != nil {
+			return fmt.Errorf("error in save histogram %s of batch: %w", histogram.metric.ID, err)
+		}
+	}
+	return nil
+}
+
+// SaveBatchMetricHandle saves batch of metrics. In atomic mode (default) the
+// whole batch is rejected if any metric is invalid. In partial mode valid
+// metrics are saved and result of every metric is returned.
+func SaveBatchMetricHandle(storage Storage) http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
 		res.Header().Set(contentType, jsonContentType)
 
+		mode := req.URL.Query().Get(batchModeParam)
+		if mode == "" {
+			mode = batchModeAtomic
+		}
+		if mode != batchModeAtomic && mode != batchModePartial {
+			errorMsg := fmt.Sprintf("must be %s or %s, got %s", batchModeAtomic, batchModePartial, mode)
+			writeFieldError(res, errorMsg, batchModeParam, http.StatusBadRequest)
+			return
+		}
+
 		bodyByte, err := io.ReadAll(req.Body)
 		defer func() {
 			err = req.Body.Close()
@@ -239,66 +378,57 @@ func SaveBatchMetricHandle(storage *types.MemStorage, syncInfo *types.SyncInfo)
 		if err != nil {
 			errorMsg := "error in read response body: " + err.Error()
 			log.Println(errorMsg)
-			http.Error(res, internalServerErrorMsg, http.StatusInternalServerError)
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
 			return
 		}
 		metricsData := make([]types.Metrics, 0, countGaugeMetrics)
 
 		err = json.Unmarshal(bodyByte, &metricsData)
-		log.Printf("Unmarshalling metricsData: %v\n", metricsData)
-
-		for _, metricData := range metricsData {
-			metricName := metricData.ID
-			switch metricData.MType {
-			case GAUGE:
-				storage.SetGauge(metricName, *metricData.Value)
-				_, ok := storage.GetGauge(metricData.ID)
-				if !ok {
-					errorMsg := fmt.Sprintf("Value gauge metric %s don't saved", metricData.ID)
-					log.Println(errorMsg)
-					http.Error(res, internalServerErrorMsg, http.StatusInternalServerError)
-					return
-				}
-			case COUNTER:
-				storage.SetCounter(metricName, *metricData.Delta)
-				_, ok := storage.GetCounter(metricData.ID)
-				if !ok {
-					errorMsg := fmt.Sprintf("Value counter metric %s don't saved", metricData.ID)
-					log.Println(errorMsg)
-					http.Error(res, internalServerErrorMsg, http.StatusInternalServerError)
+		if err != nil {
+			writeError(res, "error in decode request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		valid, result := validateBatch(req.Context(), metricsData)
+		if mode == batchModeAtomic && result.Rejected > 0 {
+			for _, item := range result.Results {
+				if !item.Accepted {
+					field := fmt.Sprintf("[%d].%s", item.Index, item.Error.Field)
+					writeFieldError(res, item.Error.Message, field, http.StatusBadRequest)
 					return
 				}
-			default:
-				bodyStr := string(bodyByte)
-				errorMsg := "Unknown metric type, must be gauge or counter, got |" + metricData.MType +
-					"|\n" + bodyStr
-				http.Error(res, errorMsg, http.StatusBadRequest)
-				return
 			}
 		}
 
-		if syncInfo.DB != nil {
-			err = retryDBWrite(syncInfo.DB, storage, retryDBWriteCount)
-			if err != nil && err.Error() != "sql: transaction has already been committed or rolled back" {
-				errorMsg := fmt.Errorf("failed to write metrics in DB: %w", err).Error()
-				log.Println(errorMsg)
-				http.Error(res, internalServerErrorMsg, http.StatusInternalServerError)
+		if mode == batchModePartial {
+			err = savePartialBatch(req.Context(), storage, valid, &result)
+			if err != nil {
+				log.Println(err)
+				writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
 				return
 			}
-		} else if syncInfo.SyncFileRecord {
-			err = retryFileWrite(syncInfo.FileMetricStorage, storage, retryFileWriteCount)
-			if err != nil {
-				errorMsg := fmt.Errorf("failed to write metrics in file: %w", err).Error()
-				http.Error(res, errorMsg, http.StatusBadRequest)
+		} else if len(valid) > 0 {
+			_, err = storage.UpdateBatch(req.Context(), valid)
+			if errors.Is(err, types.ErrBoundsMismatch) {
+				// Bounds are checked against saved histograms, so the whole batch is rejected.
+				writeFieldError(res, types.ErrBoundsMismatch.Error(), "histogram.bounds", http.StatusConflict)
+				return
+			} else if err != nil {
+				log.Println(err)
+				writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
 				return
 			}
 		}
 
-		encodedResponseData, err := json.Marshal(metricsData)
+		var encodedResponseData []byte
+		if mode == batchModePartial {
+			encodedResponseData, err = json.Marshal(result)
+		} else {
+			encodedResponseData, err = json.Marshal(valid)
+		}
 		if err != nil {
 			errorMsg := fmt.Errorf("error in serialize response for send by server: %w", err).Error()
 			log.Println(errorMsg)
-			http.Error(res, internalServerErrorMsg, http.StatusInternalServerError)
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
 			return
 		}
 
@@ -307,56 +437,79 @@ func SaveBatchMetricHandle(storage *types.MemStorage, syncInfo *types.SyncInfo)
 		if err != nil {
 			errorMsg := fmt.Errorf(errorMsgWildcard, writeHandlerErrorMsg, err).Error()
 			log.Println(errorMsg)
-			http.Error(res, internalServerErrorMsg, http.StatusInternalServerError)
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
 			return
 		}
 	}
 }
 
-func getMetricValue(mType, mName string, storage *types.MemStorage) (num interface{}, ok bool) {
-	switch mType {
-	case GAUGE:
-		num, ok = storage.GetGauge(mName)
-		return
-	case COUNTER:
-		num, ok = storage.GetCounter(mName)
-		return
-	}
-	return
-}
-
-func GetMetricHandle(storage *types.MemStorage) http.HandlerFunc {
+func GetMetricHandle(storage Storage) http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
 		res.Header().Set(contentType, textContentType)
 		metricType := req.PathValue("mType")
-		if metricType != GAUGE && metricType != COUNTER {
-			errorMsg := "Unknown metric type, must be gauge or counter, got " + metricType
-			http.Error(res, errorMsg, http.StatusBadRequest)
+		err := types.ValidateType(metricType)
+		if err != nil {
+			writeBadRequest(res, err)
 			return
 		}
 
 		metricName := req.PathValue("metric")
-		valueInterface, ok := getMetricValue(metricType, metricName, storage)
-		if !ok {
-			http.Error(res, "Metric "+metricName+" not set", http.StatusNotFound)
+		err = types.ValidateName(metricName)
+		if err != nil {
+			writeBadRequest(res, err)
+			return
+		}
+		labels, err := parseLabelParams(req)
+		if err != nil {
+			writeBadRequest(res, err)
+			return
+		}
+		metric, err := storage.Get(req.Context(), metricType, metricName, labels)
+		if errors.Is(err, ErrMetricNotFound) {
+			writeError(res, "Metric "+metricName+" not set", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Println(err)
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
 			return
 		}
 
-		switch metricValue := valueInterface.(type) {
-		case int64:
-			_, err := res.Write([]byte(strconv.FormatInt(metricValue, 10)))
+		switch {
+		case metric.Delta != nil:
+			_, err := res.Write([]byte(strconv.FormatInt(*metric.Delta, 10)))
 			if err != nil {
 				errorMsg := fmt.Errorf("error in format integer from receive data: %w", err).Error()
 				log.Println(errorMsg)
-				http.Error(res, internalServerErrorMsg, http.StatusInternalServerError)
+				writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
 				return
 			}
-		case float64:
-			_, err := res.Write([]byte(strconv.FormatFloat(metricValue, 'f', -1, 64)))
+		case metric.Value != nil:
+			_, err := res.Write([]byte(strconv.FormatFloat(*metric.Value, 'f', -1, 64)))
 			if err != nil {
 				errorMsg := fmt.Errorf("error in format float from receive data: %w", err).Error()
 				log.Println(errorMsg)
-				http.Error(res, internalServerErrorMsg, http.StatusInternalServerError)
+				writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
+				return
+			}
+		default:
+			// Histogram and summary have no single value, they are written as JSON.
+			var value []byte
+			if metric.Histogram != nil {
+				value, err = json.Marshal(metric.Histogram)
+			} else {
+				value, err = json.Marshal(metric.Summary)
+			}
+			if err != nil {
+				errorMsg := fmt.Errorf("error in serialize %s value: %w", metricType, err).Error()
+				log.Println(errorMsg)
+				writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
+				return
+			}
+			res.Header().Set(contentType, jsonContentType)
+			_, err = res.Write(value)
+			if err != nil {
+				errorMsg := fmt.Errorf(errorMsgWildcard, writeHandlerErrorMsg, err).Error()
+				log.Println(errorMsg)
 				return
 			}
 		}
@@ -365,7 +518,7 @@ func GetMetricHandle(storage *types.MemStorage) http.HandlerFunc {
 	}
 }
 
-func GetJSONMetricHandle(storage *types.MemStorage) http.HandlerFunc {
+func GetJSONMetricHandle(storage Storage) http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
 		res.Header().Set(contentType, jsonContentType)
 		bodyByte, err := io.ReadAll(req.Body)
@@ -375,7 +528,7 @@ func GetJSONMetricHandle(storage *types.MemStorage) http.HandlerFunc {
 		if err != nil {
 			errorMsg := fmt.Errorf("error in read response body: %w", err).Error()
 			log.Println(errorMsg)
-			http.Error(res, internalServerErrorMsg, http.StatusInternalServerError)
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
 			return
 		}
 		var reqJSON types.Metrics
@@ -383,35 +536,30 @@ func GetJSONMetricHandle(storage *types.MemStorage) http.HandlerFunc {
 		requestDecoder := json.NewDecoder(bytes.NewBuffer(bodyByte))
 		err = requestDecoder.Decode(&reqJSON)
 		if err != nil {
-			errorMsg := fmt.Errorf("error in  decode response body: %w", err).Error()
-			log.Println(errorMsg)
-			http.Error(res, internalServerErrorMsg, http.StatusInternalServerError)
+			writeError(res, "error in decode request body: "+err.Error(), http.StatusBadRequest)
 			return
 		}
-		mType := reqJSON.MType
-		switch mType {
-		case GAUGE:
-			value, ok := storage.GetGauge(reqJSON.ID)
-			if !ok {
-				errorMsg := fmt.Sprintf("Gauge metric %s don't saved", reqJSON.ID)
-				http.Error(res, errorMsg, http.StatusNotFound)
-				return
-			}
-			reqJSON.Value = &value
-		case COUNTER:
-			delta, ok := storage.GetCounter(reqJSON.ID)
-			if !ok {
-				errorMsg := fmt.Sprintf("Counter metric %s don't saved", reqJSON.ID)
-				http.Error(res, errorMsg, http.StatusNotFound)
-				return
-			}
-			reqJSON.Delta = &delta
+		err = reqJSON.ValidateKey()
+		if err != nil {
+			writeBadRequest(res, err)
+			return
 		}
-		responseData, err := json.Marshal(reqJSON)
+		metric, err := storage.Get(req.Context(), reqJSON.MType, reqJSON.ID, reqJSON.Labels)
+		switch {
+		case errors.Is(err, ErrMetricNotFound):
+			errorMsg := fmt.Sprintf("Metric %s %s don't saved", reqJSON.MType, reqJSON.ID)
+			writeError(res, errorMsg, http.StatusNotFound)
+			return
+		case err != nil:
+			log.Println(err)
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
+			return
+		}
+		responseData, err := json.Marshal(metric)
 		if err != nil {
 			errorMsg := fmt.Errorf("error in serialize response for send by server: %w", err).Error()
 			log.Println(errorMsg)
-			http.Error(res, internalServerErrorMsg, http.StatusInternalServerError)
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
 			return
 		}
 		res.WriteHeader(http.StatusOK)
@@ -419,25 +567,60 @@ func GetJSONMetricHandle(storage *types.MemStorage) http.HandlerFunc {
 		if err != nil {
 			errorMsg := fmt.Errorf("error in write body of response: %w", err).Error()
 			log.Println(errorMsg)
-			http.Error(res, internalServerErrorMsg, http.StatusInternalServerError)
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
 			return
 		}
 	}
 }
 
-func ListMetricHandle(storage *types.MemStorage) http.HandlerFunc {
+func ListMetricHandle(storage Storage) http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
 		res.Header().Add(contentType, "text/html")
 
+		filter, err := parseLabelParams(req)
+		if err != nil {
+			writeBadRequest(res, err)
+			return
+		}
+		metrics, err := storage.List(req.Context(), filter)
+		if err != nil {
+			log.Println(err)
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
+			return
+		}
+		// Sections of histograms and summaries are listed only if there are
+		// such metrics, so the list is the same for clients which don't use them.
 		metricsInfo := map[string]map[string]string{
-			"Gauges":   storage.GetGauges(),
-			"Counters": storage.GetCounters(),
+			"Gauges":   make(map[string]string),
+			"Counters": make(map[string]string),
+		}
+		section := func(name string) map[string]string {
+			if metricsInfo[name] == nil {
+				metricsInfo[name] = make(map[string]string)
+			}
+			return metricsInfo[name]
+		}
+		for _, metric := range metrics {
+			switch metric.MType {
+			case GAUGE:
+				metricsInfo["Gauges"][types.SeriesKey(metric.ID, metric.Labels)] =
+					strconv.FormatFloat(*metric.Value, 'f', -1, 64)
+			case COUNTER:
+				metricsInfo["Counters"][types.SeriesKey(metric.ID, metric.Labels)] =
+					strconv.FormatInt(*metric.Delta, 10)
+			case HISTOGRAM:
+				section("Histograms")[types.SeriesKey(metric.ID, metric.Labels)] =
+					fmt.Sprintf("count=%d sum=%g", metric.Histogram.Count(), metric.Histogram.Sum)
+			case SUMMARY:
+				section("Summaries")[types.SeriesKey(metric.ID, metric.Labels)] =
+					fmt.Sprintf("count=%d sum=%g", metric.Summary.Count, metric.Summary.Sum)
+			}
 		}
 		metricInfoStr, err := json.Marshal(metricsInfo)
 		if err != nil {
 			errorMsg := fmt.Errorf("error in serialize of metrics storage: %w", err).Error()
 			log.Println(errorMsg)
-			http.Error(res, internalServerErrorMsg, http.StatusInternalServerError)
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
 			return
 		}
 
@@ -446,7 +629,7 @@ func ListMetricHandle(storage *types.MemStorage) http.HandlerFunc {
 		if err != nil {
 			errorMsg := fmt.Errorf(errorMsgWildcard, writeHandlerErrorMsg, err).Error()
 			log.Println(errorMsg)
-			http.Error(res, internalServerErrorMsg, http.StatusInternalServerError)
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
 			return
 		}
 	}
@@ -459,7 +642,7 @@ func GzipHandler(internal http.Handler) http.Handler {
 		if types.IsCompressData(req.Header) && types.IsContentEncoding(req.Header) {
 			gzipReader, err := types.NewGzipReader(req.Body)
 			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
+				writeError(w, "error in decompress request body: "+err.Error(), http.StatusBadRequest)
 				return
 			}
 			req.Body = gzipReader
@@ -468,7 +651,7 @@ func GzipHandler(internal http.Handler) http.Handler {
 				if err != nil {
 					errorMsg := fmt.Errorf("error in close gzipReader: %w", err).Error()
 					log.Println(errorMsg)
-					http.Error(w, internalServerErrorMsg, http.StatusInternalServerError)
+					writeError(w, internalServerErrorMsg, http.StatusInternalServerError)
 					return
 				}
 			}()
@@ -480,7 +663,7 @@ func GzipHandler(internal http.Handler) http.Handler {
 				err := writer.Close()
 				if err != nil {
 					errorMsg := fmt.Errorf("error in close gzipWriter: %w", err)
-					http.Error(w, errorMsg.Error(), http.StatusInternalServerError)
+					writeError(w, errorMsg.Error(), http.StatusInternalServerError)
 					return
 				}
 			}()
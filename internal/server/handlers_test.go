@@ -0,0 +1,845 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/xChygyNx/metrical/internal/server/types"
+)
+
+const (
+	parallelAgents  = 16
+	reportsPerAgent = 50
+)
+
+func newTestRouter(storage Storage) http.Handler {
+	config := &Config{AgentStaleTimeout: defaultAgentStaleTimeout}
+	return newRouter(config, storage, types.NewAgentRegistry(), *zap.NewNop().Sugar())
+}
+
+func postJSON(t *testing.T, handler http.Handler, url string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	data, err := json.Marshal(body)
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	req.Header.Set(contentType, jsonContentType)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// hammer sends /update and /updates from many goroutines at once and checks
+// that no counter delta is lost. Run with -race to catch unsynchronized access.
+func hammer(t *testing.T, storage Storage) {
+	t.Helper()
+	router := newTestRouter(storage)
+
+	var wg sync.WaitGroup
+	for agent := range parallelAgents {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range reportsPerAgent {
+				value := float64(agent*reportsPerAgent + i)
+				var delta int64 = 1
+				gaugeName := fmt.Sprintf("Gauge%d", i%4)
+
+				rec := postJSON(t, router, "/update", types.Metrics{ID: gaugeName, MType: GAUGE, Value: &value})
+				assert.Equal(t, http.StatusOK, rec.Code)
+				rec = postJSON(t, router, "/update", types.Metrics{ID: "PollCount", MType: COUNTER, Delta: &delta})
+				assert.Equal(t, http.StatusOK, rec.Code)
+				rec = postJSON(t, router, "/updates", []types.Metrics{
+					{ID: gaugeName, MType: GAUGE, Value: &value},
+					{ID: "PollCount", MType: COUNTER, Delta: &delta},
+				})
+				assert.Equal(t, http.StatusOK, rec.Code)
+
+				req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+				listRec := httptest.NewRecorder()
+				router.ServeHTTP(listRec, req)
+				assert.Equal(t, http.StatusOK, listRec.Code)
+			}
+		}()
+	}
+	wg.Wait()
+
+	metric, err := storage.Get(context.Background(), COUNTER, "PollCount", nil)
+	require.NoError(t, err)
+	require.NotNil(t, metric.Delta)
+	assert.Equal(t, int64(2*parallelAgents*reportsPerAgent), *metric.Delta)
+}
+
+func TestConcurrentUpdatesMemStorage(t *testing.T) {
+	hammer(t, newMemStorage(defaultHistorySize))
+}
+
+func TestConcurrentUpdatesFileStorage(t *testing.T) {
+	tests := []struct {
+		name   string
+		period time.Duration
+	}{
+		{
+			name:   "Synchronous file record",
+			period: 0,
+		},
+		{
+			name:   "Periodic file dump",
+			period: time.Millisecond,
+		},
+		{
+			name:   "Final flush on close",
+			period: time.Hour,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "metrics.json")
+			storage := newFileStorage(newMemStorage(defaultHistorySize), path, test.period)
+			hammer(t, storage)
+			require.NoError(t, storage.Close())
+
+			restored := types.GetMemStorage()
+			require.NoError(t, restoreMetricStore(path, restored))
+			counter, ok := restored.GetCounter("PollCount")
+			assert.True(t, ok)
+			assert.Equal(t, int64(2*parallelAgents*reportsPerAgent), counter)
+		})
+	}
+}
+
+func TestRestoreLargeDump(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	storage := newFileStorage(newMemStorage(defaultHistorySize), path, time.Hour)
+	const count = 5000
+	for i := range count {
+		_, err := storage.UpdateGauge(context.Background(), fmt.Sprintf("Gauge%d", i), nil, float64(i))
+		require.NoError(t, err)
+	}
+	require.NoError(t, storage.Close())
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Greater(t, info.Size(), int64(bufio.MaxScanTokenSize), "dump is larger than one scanner line")
+
+	restored := types.GetMemStorage()
+	require.NoError(t, restoreMetricStore(path, restored))
+	gauge, ok := restored.GetGauge(fmt.Sprintf("Gauge%d", count-1))
+	assert.True(t, ok)
+	assert.InDelta(t, float64(count-1), gauge, 0)
+}
+
+func TestLabeledMetricsAreSeparated(t *testing.T) {
+	storage := newMemStorage(defaultHistorySize)
+	router := newTestRouter(storage)
+
+	legacy, web1, web2 := 1.0, 2.0, 3.0
+	rec := postJSON(t, router, "/update", types.Metrics{ID: "Alloc", MType: GAUGE, Value: &legacy})
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = postJSON(t, router, "/updates", []types.Metrics{
+		{ID: "Alloc", MType: GAUGE, Value: &web1, Labels: map[string]string{"host": "web1"}},
+		{ID: "Alloc", MType: GAUGE, Value: &web2, Labels: map[string]string{"host": "web2", "env": "prod"}},
+	})
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	tests := []struct {
+		name   string
+		url    string
+		want   string
+		status int
+	}{
+		{
+			name:   "Metric without labels",
+			url:    "/value/gauge/Alloc",
+			want:   "1",
+			status: http.StatusOK,
+		},
+		{
+			name:   "Metric with label",
+			url:    "/value/gauge/Alloc?label=host=web1",
+			want:   "2",
+			status: http.StatusOK,
+		},
+		{
+			name:   "Metric with several labels",
+			url:    "/value/gauge/Alloc?label=host=web2&label=env=prod",
+			want:   "3",
+			status: http.StatusOK,
+		},
+		{
+			name:   "Labels must match exactly",
+			url:    "/value/gauge/Alloc?label=host=web2",
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "List filtered by label",
+			url:    "/?label=env=prod",
+			want:   `{"Counters":{},"Gauges":{"Alloc{env=\"prod\",host=\"web2\"}":"3"}}`,
+			status: http.StatusOK,
+		},
+		{
+			name:   "Bad label name",
+			url:    "/value/gauge/Alloc?label=1host=web1",
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, test.url, http.NoBody)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			assert.Equal(t, test.status, rec.Code)
+			if test.want != "" {
+				assert.Equal(t, test.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAgentMetricsAreNamespaced(t *testing.T) {
+	storage := newMemStorage(defaultHistorySize)
+	router := newTestRouter(storage)
+
+	for _, agentID := range []string{"host1", "host2"} {
+		value := 1.0
+		if agentID == "host2" {
+			value = 2.0
+		}
+		data, err := json.Marshal(types.Metrics{ID: "Alloc", MType: GAUGE, Value: &value})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/update", bytes.NewReader(data))
+		req.Header.Set(types.AgentIDHeader, agentID)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	for agentID, want := range map[string]float64{"host1": 1, "host2": 2} {
+		metric, err := storage.Get(context.Background(), GAUGE, "Alloc", map[string]string{agentLabel: agentID})
+		require.NoError(t, err)
+		assert.InDelta(t, want, *metric.Value, 0)
+	}
+	_, err := storage.Get(context.Background(), GAUGE, "Alloc", nil)
+	assert.ErrorIs(t, err, ErrMetricNotFound)
+
+	value := 3.0
+	data, err := json.Marshal(types.Metrics{
+		ID: "Alloc", MType: GAUGE, Value: &value, Labels: map[string]string{agentLabel: "host2"},
+	})
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/update", bytes.NewReader(data))
+	req.Header.Set(types.AgentIDHeader, "host1")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusBadRequest, rec.Code, "agent can't write metrics of other agent")
+	var errorResponse types.ErrorResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &errorResponse))
+	assert.Equal(t, "labels.agent", errorResponse.Field)
+	metric, err := storage.Get(context.Background(), GAUGE, "Alloc", map[string]string{agentLabel: "host2"})
+	require.NoError(t, err)
+	assert.InDelta(t, 2.0, *metric.Value, 0)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/agents", http.NoBody)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var agents []types.AgentInfo
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &agents))
+	require.Len(t, agents, 2)
+	assert.Equal(t, "host1", agents[0].ID)
+	assert.False(t, agents[0].Stale)
+}
+
+func TestOnlyAcceptedWritesRegisterAgent(t *testing.T) {
+	const key = "secret"
+	value := 1.0
+	body, err := json.Marshal(types.Metrics{ID: "Alloc", MType: GAUGE, Value: &value})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		method     string
+		url        string
+		realIP     string
+		sign       string
+		registered bool
+	}{
+		{
+			name:       "Signed write from trusted subnet",
+			method:     http.MethodPost,
+			url:        "/update",
+			realIP:     "10.1.2.3",
+			sign:       types.Sign(body, key),
+			registered: true,
+		},
+		{name: "Read", method: http.MethodGet, url: "/api/v1/agents", realIP: "10.1.2.3"},
+		{name: "Write without signature", method: http.MethodPost, url: "/update", realIP: "10.1.2.3"},
+		{
+			name:   "Write from outside",
+			method: http.MethodPost,
+			url:    "/update",
+			realIP: "192.168.1.5",
+			sign:   types.Sign(body, key),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := &Config{AgentStaleTimeout: defaultAgentStaleTimeout, Key: key}
+			require.NoError(t, config.TrustedSubnet.Set("10.0.0.0/8"))
+			agents := types.NewAgentRegistry()
+			router := newRouter(config, newMemStorage(defaultHistorySize), agents, *zap.NewNop().Sugar())
+
+			req := httptest.NewRequest(test.method, test.url, bytes.NewReader(body))
+			req.Header.Set(contentType, jsonContentType)
+			req.Header.Set(realIPHeader, test.realIP)
+			req.Header.Set(types.AgentIDHeader, "host1")
+			if test.sign != "" {
+				req.Header.Set(types.HashHeader, test.sign)
+			}
+			router.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, test.registered, len(agents.List(time.Now(), time.Minute)) == 1)
+		})
+	}
+}
+
+func TestSignedUpdates(t *testing.T) {
+	const key = "secret"
+	value := 1.5
+	body, err := json.Marshal(types.Metrics{ID: "Alloc", MType: GAUGE, Value: &value})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		sign   string
+		status int
+	}{
+		{
+			name:   "Valid signature",
+			sign:   types.Sign(body, key),
+			status: http.StatusOK,
+		},
+		{
+			name:   "Signature by other key",
+			sign:   types.Sign(body, "other"),
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "No signature",
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage := newMemStorage(defaultHistorySize)
+			config := &Config{AgentStaleTimeout: defaultAgentStaleTimeout, Key: key}
+			router := newRouter(config, storage, types.NewAgentRegistry(), *zap.NewNop().Sugar())
+
+			req := httptest.NewRequest(http.MethodPost, "/update", bytes.NewReader(body))
+			req.Header.Set(contentType, jsonContentType)
+			if test.sign != "" {
+				req.Header.Set(types.HashHeader, test.sign)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			assert.Equal(t, test.status, rec.Code)
+			assert.True(t, types.CheckSign(rec.Body.Bytes(), key, rec.Header().Get(types.HashHeader)))
+
+			_, err := storage.Get(context.Background(), GAUGE, "Alloc", nil)
+			if test.status == http.StatusOK {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrMetricNotFound)
+			}
+		})
+	}
+}
+
+func TestSignedPathUpdates(t *testing.T) {
+	const key = "secret"
+	storage := newMemStorage(defaultHistorySize)
+	config := &Config{AgentStaleTimeout: defaultAgentStaleTimeout, Key: key}
+	router := newRouter(config, storage, types.NewAgentRegistry(), *zap.NewNop().Sugar())
+
+	empty := signResponse(key)(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusOK)
+	}))
+	rec := httptest.NewRecorder()
+	empty.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+	assert.Empty(t, rec.Header().Get(types.HashHeader), "empty response isn't signed")
+
+	const target = "/update/gauge/Alloc/1.5?label=env=prod"
+	tests := []struct {
+		name   string
+		sign   string
+		status int
+	}{
+		{
+			name:   "Signature of method, path and query",
+			sign:   types.Sign(types.RequestSignData(http.MethodPost, target), key),
+			status: http.StatusOK,
+		},
+		{
+			name:   "Signature without query",
+			sign:   types.Sign(types.RequestSignData(http.MethodPost, "/update/gauge/Alloc/1.5"), key),
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "Replayed signature of empty body",
+			sign:   types.Sign(nil, key),
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "Signature of other path",
+			sign:   types.Sign(types.RequestSignData(http.MethodPost, "/update/gauge/Alloc/2?label=env=prod"), key),
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, target, http.NoBody)
+			req.Header.Set(types.HashHeader, test.sign)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			assert.Equal(t, test.status, rec.Code)
+		})
+	}
+}
+
+func TestMalformedGzipBody(t *testing.T) {
+	router := newTestRouter(newMemStorage(defaultHistorySize))
+	req := httptest.NewRequest(http.MethodPost, "/update", strings.NewReader(`{"id":"Alloc"}`))
+	req.Header.Set(contentType, jsonContentType)
+	req.Header.Set("Content-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	var body types.ErrorResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, http.StatusBadRequest, body.Code)
+	assert.NotEmpty(t, body.Message)
+}
+
+func TestEncryptedUpdates(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	storage := newMemStorage(defaultHistorySize)
+	router := decryptHandler(privateKey)(newTestRouter(storage))
+
+	metrics := make([]types.Metrics, 0, countGaugeMetrics)
+	for i := range countGaugeMetrics {
+		value := float64(i)
+		metrics = append(metrics, types.Metrics{ID: fmt.Sprintf("Gauge%d", i), MType: GAUGE, Value: &value})
+	}
+	data, err := json.Marshal(metrics)
+	require.NoError(t, err)
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	_, err = gzipWriter.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, gzipWriter.Close())
+
+	ciphertext, scheme, err := types.Encrypt(&privateKey.PublicKey, compressed.Bytes())
+	require.NoError(t, err)
+	require.Equal(t, types.EncryptionHybrid, scheme)
+
+	req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(ciphertext))
+	req.Header.Set(contentType, jsonContentType)
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set(types.EncryptionHeader, scheme)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	metric, err := storage.Get(context.Background(), GAUGE, "Gauge7", nil)
+	require.NoError(t, err)
+	assert.InDelta(t, 7, *metric.Value, 0)
+
+	req = httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(compressed.Bytes()))
+	req.Header.Set(contentType, jsonContentType)
+	req.Header.Set(types.EncryptionHeader, scheme)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestTrustedSubnet(t *testing.T) {
+	config := &Config{AgentStaleTimeout: defaultAgentStaleTimeout}
+	require.NoError(t, config.TrustedSubnet.Set("10.0.0.0/8"))
+	require.NoError(t, config.ReadSubnet.Set("192.168.1.0/24"))
+	router := newRouter(config, newMemStorage(defaultHistorySize), types.NewAgentRegistry(), *zap.NewNop().Sugar())
+	value := 1.0
+	body, err := json.Marshal(types.Metrics{ID: "Alloc", MType: GAUGE, Value: &value})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		method     string
+		url        string
+		realIP     string
+		remoteAddr string
+		status     int
+	}{
+		{
+			name:   "Write from trusted subnet",
+			method: http.MethodPost,
+			url:    "/update",
+			realIP: "10.1.2.3",
+			status: http.StatusOK,
+		},
+		{
+			name:   "Write from outside",
+			method: http.MethodPost,
+			url:    "/update",
+			realIP: "192.168.1.5",
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "Write with bad X-Real-IP",
+			method: http.MethodPost,
+			url:    "/updates/",
+			realIP: "not an ip",
+			status: http.StatusForbidden,
+		},
+		{
+			name:       "Read from read subnet by remote address",
+			method:     http.MethodGet,
+			url:        "/",
+			remoteAddr: "192.168.1.5:40000",
+			status:     http.StatusOK,
+		},
+		{
+			name:   "Read from write subnet",
+			method: http.MethodGet,
+			url:    "/metrics",
+			realIP: "10.1.2.3",
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "Ping is open",
+			method: http.MethodGet,
+			url:    "/ping",
+			realIP: "172.16.0.1",
+			status: http.StatusInternalServerError,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.url, bytes.NewReader(body))
+			req.Header.Set(contentType, jsonContentType)
+			if test.realIP != "" {
+				req.Header.Set(realIPHeader, test.realIP)
+			}
+			if test.remoteAddr != "" {
+				req.RemoteAddr = test.remoteAddr
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			assert.Equal(t, test.status, rec.Code)
+		})
+	}
+}
+
+func TestValidationErrors(t *testing.T) {
+	router := newTestRouter(newMemStorage(defaultHistorySize))
+	longName := strings.Repeat("a", types.MaxNameLength+1)
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		body   string
+		status int
+		field  string
+	}{
+		{
+			name:   "Malformed JSON",
+			method: http.MethodPost,
+			url:    "/update",
+			body:   `{"id":`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "Gauge without value",
+			method: http.MethodPost,
+			url:    "/update",
+			body:   `{"id":"Alloc","type":"gauge"}`,
+			status: http.StatusBadRequest,
+			field:  "value",
+		},
+		{
+			name:   "Counter without delta",
+			method: http.MethodPost,
+			url:    "/update",
+			body:   `{"id":"PollCount","type":"counter","value":1}`,
+			status: http.StatusBadRequest,
+			field:  "delta",
+		},
+		{
+			name:   "Missing name",
+			method: http.MethodPost,
+			url:    "/update",
+			body:   `{"type":"gauge","value":1}`,
+			status: http.StatusBadRequest,
+			field:  "id",
+		},
+		{
+			name:   "Unknown type",
+			method: http.MethodPost,
+			url:    "/update",
+			body:   `{"id":"Alloc","type":"timer","value":1}`,
+			status: http.StatusBadRequest,
+			field:  "type",
+		},
+		{
+			name:   "Too long name",
+			method: http.MethodPost,
+			url:    "/update",
+			body:   `{"id":"` + longName + `","type":"gauge","value":1}`,
+			status: http.StatusBadRequest,
+			field:  "id",
+		},
+		{
+			name:   "Invalid item of batch",
+			method: http.MethodPost,
+			url:    "/updates",
+			body:   `[{"id":"Alloc","type":"gauge","value":1},{"id":"Bad name","type":"gauge","value":1}]`,
+			status: http.StatusBadRequest,
+			field:  "[1].id",
+		},
+		{
+			name:   "NaN in URL path",
+			method: http.MethodPost,
+			url:    "/update/gauge/Alloc/NaN",
+			status: http.StatusBadRequest,
+			field:  "value",
+		},
+		{
+			name:   "Infinity in URL path",
+			method: http.MethodPost,
+			url:    "/update/gauge/Alloc/+Inf",
+			status: http.StatusBadRequest,
+			field:  "value",
+		},
+		{
+			name:   "Not numeric value in URL path",
+			method: http.MethodPost,
+			url:    "/update/counter/PollCount/1.5",
+			status: http.StatusBadRequest,
+			field:  "value",
+		},
+		{
+			name:   "Bad label of read request",
+			method: http.MethodGet,
+			url:    "/value/gauge/Alloc?label=1host=web1",
+			status: http.StatusBadRequest,
+			field:  "label",
+		},
+		{
+			name:   "Bad label of exposition",
+			method: http.MethodGet,
+			url:    "/metrics?label=host",
+			status: http.StatusBadRequest,
+			field:  "label",
+		},
+		{
+			name:   "Not found metric",
+			method: http.MethodGet,
+			url:    "/value/gauge/Alloc",
+			status: http.StatusNotFound,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.url, strings.NewReader(test.body))
+			req.Header.Set(contentType, jsonContentType)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			assert.Equal(t, test.status, rec.Code)
+			assert.Equal(t, jsonContentType, rec.Header().Get(contentType))
+
+			var body types.ErrorResponse
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+			assert.Equal(t, test.status, body.Code)
+			assert.Equal(t, test.field, body.Field)
+			assert.NotEmpty(t, body.Message)
+		})
+	}
+}
+
+func TestBatchModes(t *testing.T) {
+	batch := `[{"id":"Alloc","type":"gauge","value":1},{"id":"Bad name","type":"gauge","value":2},` +
+		`{"id":"PollCount","type":"counter","delta":3}]`
+	tests := []struct {
+		name   string
+		url    string
+		status int
+		saved  bool
+		result *types.BatchResult
+	}{
+		{
+			name:   "Atomic batch is rejected as whole",
+			url:    "/updates",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "Explicit atomic mode",
+			url:    "/updates?mode=atomic",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "Partial batch saves valid metrics",
+			url:    "/updates?mode=partial",
+			status: http.StatusOK,
+			saved:  true,
+			result: &types.BatchResult{
+				Results: []types.BatchItemResult{
+					{ID: "Alloc", Index: 0, Accepted: true},
+					{
+						ID:    "Bad name",
+						Index: 1,
+						Error: &types.ErrorResponse{
+							Code:    http.StatusBadRequest,
+							Message: `"Bad name" must match [a-zA-Z0-9_.:-]+`,
+							Field:   "id",
+						},
+					},
+					{ID: "PollCount", Index: 2, Accepted: true},
+				},
+				Accepted: 2,
+				Rejected: 1,
+			},
+		},
+		{
+			name:   "Unknown mode",
+			url:    "/updates?mode=best-effort",
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage := newMemStorage(defaultHistorySize)
+			router := newTestRouter(storage)
+			req := httptest.NewRequest(http.MethodPost, test.url, strings.NewReader(batch))
+			req.Header.Set(contentType, jsonContentType)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			assert.Equal(t, test.status, rec.Code)
+
+			_, err := storage.Get(context.Background(), COUNTER, "PollCount", nil)
+			if test.saved {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrMetricNotFound, "nothing is saved from rejected batch")
+			}
+			if test.result != nil {
+				var result types.BatchResult
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+				assert.Equal(t, *test.result, result)
+			}
+		})
+	}
+}
+
+func TestMemStorageBatchIsAtomic(t *testing.T) {
+	storage := newMemStorage(defaultHistorySize)
+	value, delta := 1.0, int64(2)
+	_, err := storage.UpdateBatch(context.Background(), []types.Metrics{
+		{ID: "Alloc", MType: GAUGE, Value: &value},
+		{ID: "PollCount", MType: COUNTER, Delta: &delta},
+		{ID: "Other", MType: "timer"},
+	})
+	assert.ErrorIs(t, err, ErrUnknownType)
+	metrics, err := storage.List(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Empty(t, metrics)
+
+	_, err = storage.UpdateBatch(context.Background(), []types.Metrics{
+		{ID: "PollCount", MType: COUNTER, Delta: &delta},
+		{ID: "PollCount", MType: COUNTER, Delta: &delta},
+	})
+	require.NoError(t, err)
+	metric, err := storage.Get(context.Background(), COUNTER, "PollCount", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), *metric.Delta)
+}
+
+func TestHistogramsAndSummaries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	storage := newFileStorage(newMemStorage(defaultHistorySize), path, 0)
+	router := newTestRouter(storage)
+	histogram := func(sum float64, counts ...int64) *types.Histogram {
+		return &types.Histogram{Bounds: []float64{0.1, 1}, Counts: counts, Sum: sum}
+	}
+	summary := &types.Summary{Quantiles: []types.Quantile{{Quantile: 0.5, Value: 0.2}}, Sum: 3, Count: 10}
+
+	rec := postJSON(t, router, "/update", types.Metrics{ID: "latency", MType: HISTOGRAM, Histogram: histogram(1, 1, 2, 0)})
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = postJSON(t, router, "/updates", []types.Metrics{
+		{ID: "latency", MType: HISTOGRAM, Histogram: histogram(2, 0, 1, 1)},
+		{ID: "latency", MType: HISTOGRAM, Histogram: histogram(0.5, 1, 0, 0)},
+		{ID: "duration", MType: SUMMARY, Summary: summary},
+	})
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = postJSON(t, router, "/value", types.Metrics{ID: "latency", MType: HISTOGRAM})
+	require.Equal(t, http.StatusOK, rec.Code)
+	var metric types.Metrics
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &metric))
+	assert.Equal(t, histogram(3.5, 2, 3, 1), metric.Histogram, "observations are added")
+
+	req := httptest.NewRequest(http.MethodGet, "/value/summary/duration", http.NoBody)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"quantiles":[{"quantile":0.5,"value":0.2}],"sum":3,"count":10}`, rec.Body.String())
+
+	other := &types.Histogram{Bounds: []float64{1}, Counts: []int64{1, 1}}
+	rec = postJSON(t, router, "/update", types.Metrics{ID: "latency", MType: HISTOGRAM, Histogram: other})
+	assert.Equal(t, http.StatusConflict, rec.Code, "bounds can't be changed")
+	rec = postJSON(t, router, "/updates", []types.Metrics{{ID: "latency", MType: HISTOGRAM, Histogram: other}})
+	assert.Equal(t, http.StatusConflict, rec.Code, "bounds can't be changed by batch")
+	value := 1.0
+	rec = postJSON(t, router, "/updates?mode=partial", []types.Metrics{
+		{ID: "latency", MType: HISTOGRAM, Histogram: other},
+		{ID: "Alloc", MType: GAUGE, Value: &value},
+	})
+	require.Equal(t, http.StatusOK, rec.Code, "partial batch is saved")
+	var result types.BatchResult
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.Equal(t, 1, result.Accepted)
+	assert.Equal(t, 1, result.Rejected)
+	require.NotNil(t, result.Results[0].Error)
+	assert.Equal(t, http.StatusConflict, result.Results[0].Error.Code)
+	assert.Equal(t, "histogram.bounds", result.Results[0].Error.Field)
+	assert.True(t, result.Results[1].Accepted)
+	req = httptest.NewRequest(http.MethodPost, "/update/histogram/latency/1", http.NoBody)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	require.NoError(t, storage.Close())
+	restored := types.GetMemStorage()
+	require.NoError(t, restoreMetricStore(path, restored))
+	saved, ok := restored.GetHistogram("latency")
+	assert.True(t, ok)
+	assert.Equal(t, *histogram(3.5, 2, 3, 1), saved)
+	savedSummary, ok := restored.GetSummary("duration")
+	assert.True(t, ok)
+	assert.Equal(t, *summary, savedSummary)
+}
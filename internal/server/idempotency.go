@@ -0,0 +1,289 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/xChygyNx/metrical/internal/server/types"
+)
+
+const (
+	maxIdempotencyKeySize = 100
+	replayedHeader        = "Idempotent-Replayed"
+	keyReusedMsg          = "Idempotency key is already used for other request"
+	// Status of response to request which is still being processed.
+	pendingResponseStatus = -1
+	// Key reserved by crashed server is freed after pendingKeyTimeout.
+	pendingKeyTimeout      = 30 * time.Second
+	pendingKeyPollInterval = 100 * time.Millisecond
+)
+
+// storedResponse is response remembered for idempotency key. RequestHash
+// detects reuse of key for other request.
+type storedResponse struct {
+	RequestHash string
+	ContentType string
+	Body        []byte
+	Status      int
+}
+
+// responseStore persists remembered responses, so they survive restart and
+// are shared by servers with one data base. Key is reserved before request is
+// processed, so the same request sent to two servers is applied once.
+type responseStore interface {
+	// ReserveResponse atomically reserves key for request until expiresAt.
+	// If key is already reserved, its response is returned, which has
+	// pendingResponseStatus until the other request is answered.
+	ReserveResponse(ctx context.Context, key, requestHash string, expiresAt time.Time) (storedResponse, bool, error)
+	// SaveResponse remembers response for reserved key.
+	SaveResponse(ctx context.Context, key string, response storedResponse, expiresAt time.Time) error
+	// ReleaseResponse forgets reserved key without response, so request can
+	// be retried.
+	ReleaseResponse(ctx context.Context, key string) error
+}
+
+type idempotencyEntry struct {
+	done     chan struct{}
+	expires  time.Time
+	response storedResponse
+	saved    bool
+}
+
+type expiringKey struct {
+	expires time.Time
+	key     string
+}
+
+// idempotencyKeys remembers responses to write requests by their keys for
+// ttl. Request with key which is being processed waits for the first one.
+type idempotencyKeys struct {
+	store   responseStore
+	entries map[string]*idempotencyEntry
+	order   []expiringKey
+	ttl     time.Duration
+	mu      sync.Mutex
+}
+
+// newIdempotencyKeys keeps keys in memory and also in storage if it can
+// persist them. Zero ttl disables idempotency keys.
+func newIdempotencyKeys(ttl time.Duration, storage Storage) *idempotencyKeys {
+	if ttl <= 0 {
+		return nil
+	}
+	store, _ := storage.(responseStore)
+	return &idempotencyKeys{store: store, entries: make(map[string]*idempotencyEntry), ttl: ttl}
+}
+
+// begin returns entry of key and whether caller owns it and has to process
+// request.
+func (ik *idempotencyKeys) begin(key string, now time.Time) (*idempotencyEntry, bool) {
+	ik.mu.Lock()
+	defer ik.mu.Unlock()
+	ik.expire(now)
+	entry, ok := ik.entries[key]
+	if ok {
+		return entry, false
+	}
+	entry = &idempotencyEntry{done: make(chan struct{})}
+	ik.entries[key] = entry
+	return entry, true
+}
+
+// finish remembers response of owned entry, nil response forgets key so
+// request can be retried.
+func (ik *idempotencyKeys) finish(key string, entry *idempotencyEntry, response *storedResponse, now time.Time) {
+	ik.mu.Lock()
+	defer ik.mu.Unlock()
+	if response == nil {
+		delete(ik.entries, key)
+	} else {
+		entry.response = *response
+		entry.saved = true
+		entry.expires = now.Add(ik.ttl)
+		ik.order = append(ik.order, expiringKey{expires: entry.expires, key: key})
+	}
+	close(entry.done)
+}
+
+// expire forgets keys older than ttl. Keys are expired in order they are
+// remembered, as all of them live for the same ttl.
+func (ik *idempotencyKeys) expire(now time.Time) {
+	for len(ik.order) > 0 && !ik.order[0].expires.After(now) {
+		item := ik.order[0]
+		ik.order = ik.order[1:]
+		entry, ok := ik.entries[item.key]
+		if ok && entry.saved && entry.expires.Equal(item.expires) {
+			delete(ik.entries, item.key)
+		}
+	}
+}
+
+// reserve reserves key in store for request, waiting while it is processed
+// by other server. It returns response to replay or nil if caller reserved
+// the key and has to process request.
+func (ik *idempotencyKeys) reserve(ctx context.Context, key, requestHash string) (*storedResponse, error) {
+	for {
+		stored, reserved, err := ik.store.ReserveResponse(ctx, key, requestHash, time.Now().Add(pendingKeyTimeout))
+		if err != nil {
+			return nil, err
+		}
+		if reserved {
+			return nil, nil
+		}
+		if stored.Status != pendingResponseStatus {
+			return &stored, nil
+		}
+		select {
+		case <-time.After(pendingKeyPollInterval):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("error in wait for response of idempotency key: %w", ctx.Err())
+		}
+	}
+}
+
+// complete saves response of reserved key in store or releases the key if
+// there is no response to remember.
+func (ik *idempotencyKeys) complete(ctx context.Context, key string, response *storedResponse) {
+	// Key is released even if request is canceled.
+	ctx = context.WithoutCancel(ctx)
+	var err error
+	if response == nil {
+		err = ik.store.ReleaseResponse(ctx, key)
+	} else {
+		err = ik.store.SaveResponse(ctx, key, *response, time.Now().Add(ik.ttl))
+	}
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// responseRecorder passes response through and keeps its copy.
+type responseRecorder struct {
+	http.ResponseWriter
+	body   bytes.Buffer
+	status int
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if rr.status == 0 {
+		rr.status = status
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(data []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	rr.body.Write(data)
+	return rr.ResponseWriter.Write(data)
+}
+
+func replayResponse(res http.ResponseWriter, response storedResponse, requestHash string) {
+	if response.RequestHash != requestHash {
+		writeFieldError(res, keyReusedMsg, types.IdempotencyKeyHeader, http.StatusUnprocessableEntity)
+		return
+	}
+	res.Header().Set(contentType, response.ContentType)
+	res.Header().Set(replayedHeader, "true")
+	res.WriteHeader(response.Status)
+	_, err := res.Write(response.Body)
+	if err != nil {
+		errorMsg := fmt.Errorf(errorMsgWildcard, writeHandlerErrorMsg, err).Error()
+		log.Println(errorMsg)
+	}
+}
+
+// idempotent answers write request with Idempotency-Key header by response
+// remembered for the key, so retried request doesn't add counter deltas
+// again. Server errors are not remembered, so such requests can be retried.
+func idempotent(keys *idempotencyKeys, next http.Handler) http.Handler {
+	if keys == nil {
+		return next
+	}
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		key := req.Header.Get(types.IdempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(res, req)
+			return
+		}
+		if len(key) > maxIdempotencyKeySize {
+			errorMsg := fmt.Sprintf("must be at most %d bytes", maxIdempotencyKeySize)
+			writeFieldError(res, errorMsg, types.IdempotencyKeyHeader, http.StatusBadRequest)
+			return
+		}
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			log.Println(fmt.Errorf("error in read request body: %w", err))
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
+			return
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		hash := sha256.New()
+		hash.Write([]byte(req.Method + " " + req.URL.RequestURI() + "\n"))
+		hash.Write(body)
+		requestHash := hex.EncodeToString(hash.Sum(nil))
+
+		entry, owner := keys.begin(key, time.Now())
+		for !owner {
+			select {
+			case <-entry.done:
+			case <-req.Context().Done():
+				return
+			}
+			if entry.saved {
+				replayResponse(res, entry.response, requestHash)
+				return
+			}
+			entry, owner = keys.begin(key, time.Now())
+		}
+
+		// Key is forgotten unless response is remembered, even if handler panics.
+		var remembered *storedResponse
+		reserved := false
+		defer func() {
+			if reserved {
+				keys.complete(req.Context(), key, remembered)
+			}
+			keys.finish(key, entry, remembered, time.Now())
+		}()
+		if keys.store != nil {
+			stored, err := keys.reserve(req.Context(), key, requestHash)
+			if err != nil {
+				// Without reserved key request could be applied twice.
+				log.Println(err)
+				writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
+				return
+			}
+			if stored != nil {
+				remembered = stored
+				replayResponse(res, *stored, requestHash)
+				return
+			}
+			reserved = true
+		}
+
+		recorder := &responseRecorder{ResponseWriter: res}
+		next.ServeHTTP(recorder, req)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		if recorder.status >= http.StatusInternalServerError {
+			return
+		}
+		remembered = &storedResponse{
+			RequestHash: requestHash,
+			ContentType: res.Header().Get(contentType),
+			Body:        recorder.body.Bytes(),
+			Status:      recorder.status,
+		}
+	})
+}
@@ -0,0 +1,277 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/xChygyNx/metrical/internal/server/types"
+)
+
+// mapResponseStore stands in for data base which keeps keys across restarts.
+type mapResponseStore struct {
+	responses map[string]storedResponse
+	mu        sync.Mutex
+}
+
+func (ms *mapResponseStore) ReserveResponse(_ context.Context, key, requestHash string,
+	_ time.Time) (storedResponse, bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	response, ok := ms.responses[key]
+	if ok {
+		return response, false, nil
+	}
+	ms.responses[key] = storedResponse{RequestHash: requestHash, Status: pendingResponseStatus}
+	return response, true, nil
+}
+
+func (ms *mapResponseStore) SaveResponse(_ context.Context, key string, response storedResponse, _ time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.responses[key] = response
+	return nil
+}
+
+func (ms *mapResponseStore) ReleaseResponse(_ context.Context, key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.responses, key)
+	return nil
+}
+
+func newStoredKeys(store responseStore) *idempotencyKeys {
+	return &idempotencyKeys{store: store, entries: make(map[string]*idempotencyEntry), ttl: time.Minute}
+}
+
+func sendWithKey(handler http.Handler, url, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	req.Header.Set(contentType, jsonContentType)
+	if key != "" {
+		req.Header.Set(types.IdempotencyKeyHeader, key)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestIdempotentUpdates(t *testing.T) {
+	const counterBody = `{"id":"PollCount","type":"counter","delta":5}`
+	tests := []struct {
+		name    string
+		url     string
+		keys    []string
+		bodies  []string
+		want    int64
+		replays int
+		status  int
+	}{
+		{
+			name:    "Repeated request is applied once",
+			url:     "/update",
+			keys:    []string{"report-1", "report-1", "report-1"},
+			bodies:  []string{counterBody, counterBody, counterBody},
+			want:    5,
+			replays: 2,
+			status:  http.StatusOK,
+		},
+		{
+			name:    "Repeated batch is applied once",
+			url:     "/updates?mode=partial",
+			keys:    []string{"report-1", "report-1"},
+			bodies:  []string{"[" + counterBody + "]", "[" + counterBody + "]"},
+			want:    5,
+			replays: 1,
+			status:  http.StatusOK,
+		},
+		{
+			name:   "Different keys are applied",
+			url:    "/update",
+			keys:   []string{"report-1", "report-2"},
+			bodies: []string{counterBody, counterBody},
+			want:   10,
+			status: http.StatusOK,
+		},
+		{
+			name:   "Requests without key are applied",
+			url:    "/update",
+			keys:   []string{"", ""},
+			bodies: []string{counterBody, counterBody},
+			want:   10,
+			status: http.StatusOK,
+		},
+		{
+			name:   "Key reused for other request",
+			url:    "/update",
+			keys:   []string{"report-1", "report-1"},
+			bodies: []string{counterBody, `{"id":"PollCount","type":"counter","delta":7}`},
+			want:   5,
+			status: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "Too long key",
+			url:    "/update",
+			keys:   []string{strings.Repeat("k", maxIdempotencyKeySize+1)},
+			bodies: []string{counterBody},
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage := newMemStorage(defaultHistorySize)
+			config := &Config{AgentStaleTimeout: defaultAgentStaleTimeout, IdempotencyTTL: defaultIdempotencyTTL}
+			router := newRouter(config, storage, types.NewAgentRegistry(), *zap.NewNop().Sugar())
+
+			var first, last *httptest.ResponseRecorder
+			replays := 0
+			for i, key := range test.keys {
+				last = sendWithKey(router, test.url, key, test.bodies[i])
+				if first == nil {
+					first = last
+				}
+				if last.Header().Get(replayedHeader) != "" {
+					assert.Equal(t, first.Body.String(), last.Body.String(), "original response is returned")
+					replays++
+				}
+			}
+			assert.Equal(t, test.status, last.Code)
+			assert.Equal(t, test.replays, replays)
+
+			metric, err := storage.Get(context.Background(), COUNTER, "PollCount", nil)
+			if test.want == 0 {
+				assert.ErrorIs(t, err, ErrMetricNotFound)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, *metric.Delta)
+		})
+	}
+}
+
+func TestIdempotentConcurrentDuplicates(t *testing.T) {
+	var calls atomic.Int64
+	release := make(chan struct{})
+	handler := idempotent(newIdempotencyKeys(time.Minute, nil), http.HandlerFunc(
+		func(res http.ResponseWriter, _ *http.Request) {
+			calls.Add(1)
+			<-release
+			_, _ = res.Write([]byte("applied"))
+		}))
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := sendWithKey(handler, "/update", "report-1", "{}")
+			assert.Equal(t, "applied", rec.Body.String())
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int64(1), calls.Load())
+}
+
+func TestIdempotentServerErrorIsRetried(t *testing.T) {
+	var calls atomic.Int64
+	handler := idempotent(newIdempotencyKeys(time.Minute, nil), http.HandlerFunc(
+		func(res http.ResponseWriter, _ *http.Request) {
+			if calls.Add(1) == 1 {
+				writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
+				return
+			}
+			_, _ = res.Write([]byte("applied"))
+		}))
+
+	assert.Equal(t, http.StatusInternalServerError, sendWithKey(handler, "/update", "report-1", "{}").Code)
+	assert.Equal(t, http.StatusOK, sendWithKey(handler, "/update", "report-1", "{}").Code)
+	assert.Equal(t, http.StatusOK, sendWithKey(handler, "/update", "report-1", "{}").Code)
+	assert.Equal(t, int64(2), calls.Load())
+}
+
+func TestIdempotencyKeysExpire(t *testing.T) {
+	keys := newIdempotencyKeys(time.Minute, nil)
+	now := time.Now()
+	entry, owner := keys.begin("report-1", now)
+	require.True(t, owner)
+	keys.finish("report-1", entry, &storedResponse{Status: http.StatusOK}, now)
+
+	_, owner = keys.begin("report-1", now.Add(30*time.Second))
+	assert.False(t, owner, "key is remembered within ttl")
+	_, owner = keys.begin("report-1", now.Add(2*time.Minute))
+	assert.True(t, owner, "key is forgotten after ttl")
+	assert.Nil(t, newIdempotencyKeys(0, nil), "zero ttl disables keys")
+}
+
+func TestIdempotencyKeysAreStored(t *testing.T) {
+	store := &mapResponseStore{responses: make(map[string]storedResponse)}
+	var calls atomic.Int64
+	next := http.HandlerFunc(func(res http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		_, _ = res.Write([]byte("applied"))
+	})
+
+	sendWithKey(idempotent(newStoredKeys(store), next), "/update", "report-1", "{}")
+	// Server is restarted, memory is empty but data base keeps the key.
+	rec := sendWithKey(idempotent(newStoredKeys(store), next), "/update", "report-1", "{}")
+	assert.Equal(t, "applied", rec.Body.String())
+	assert.Equal(t, "true", rec.Header().Get(replayedHeader))
+	assert.Equal(t, int64(1), calls.Load())
+}
+
+func TestIdempotencyKeysAreReservedAcrossServers(t *testing.T) {
+	store := &mapResponseStore{responses: make(map[string]storedResponse)}
+	var calls atomic.Int64
+	release := make(chan struct{})
+	next := http.HandlerFunc(func(res http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		<-release
+		_, _ = res.Write([]byte("applied"))
+	})
+	// Two servers share one data base.
+	servers := []http.Handler{idempotent(newStoredKeys(store), next), idempotent(newStoredKeys(store), next)}
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := sendWithKey(servers[i%len(servers)], "/update", "report-1", "{}")
+			assert.Equal(t, "applied", rec.Body.String())
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int64(1), calls.Load())
+}
+
+func TestIdempotencyKeyIsReleasedOnServerError(t *testing.T) {
+	store := &mapResponseStore{responses: make(map[string]storedResponse)}
+	var calls atomic.Int64
+	next := http.HandlerFunc(func(res http.ResponseWriter, _ *http.Request) {
+		if calls.Add(1) == 1 {
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
+			return
+		}
+		_, _ = res.Write([]byte("applied"))
+	})
+
+	rec := sendWithKey(idempotent(newStoredKeys(store), next), "/update", "report-1", "{}")
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	// Retry reaches other server, which processes the released key.
+	rec = sendWithKey(idempotent(newStoredKeys(store), next), "/update", "report-1", "{}")
+	assert.Equal(t, "applied", rec.Body.String())
+	assert.Empty(t, rec.Header().Get(replayedHeader))
+	assert.Equal(t, int64(2), calls.Load())
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/xChygyNx/metrical/internal/server/types"
+)
+
+type memStorage struct {
+	storage *types.MemStorage
+	history *types.History
+}
+
+func newMemStorage(historySize int) *memStorage {
+	return &memStorage{
+		storage: types.GetMemStorage(),
+		history: types.NewHistory(historySize),
+	}
+}
+
+func (ms *memStorage) UpdateGauge(_ context.Context, name string, labels map[string]string,
+	value float64) (float64, error) {
+	key := types.SeriesKey(name, labels)
+	ms.storage.SetGauge(key, value)
+	ms.history.Add(GAUGE, key, types.Sample{Timestamp: time.Now(), Value: value})
+	return value, nil
+}
+
+func (ms *memStorage) AddCounter(_ context.Context, name string, labels map[string]string,
+	delta int64) (int64, error) {
+	key := types.SeriesKey(name, labels)
+	saved := ms.storage.SetCounter(key, delta)
+	ms.history.Add(COUNTER, key, types.Sample{Timestamp: time.Now(), Value: float64(saved)})
+	return saved, nil
+}
+
+// History of histograms and summaries isn't kept, their range queries
+// return no samples.
+func (ms *memStorage) AddHistogram(_ context.Context, name string, labels map[string]string,
+	histogram types.Histogram) (types.Histogram, error) {
+	saved, err := ms.storage.AddHistogram(types.SeriesKey(name, labels), histogram)
+	if err != nil {
+		return saved, fmt.Errorf("%w: %s", err, name)
+	}
+	return saved, nil
+}
+
+func (ms *memStorage) UpdateSummary(_ context.Context, name string, labels map[string]string,
+	summary types.Summary) (types.Summary, error) {
+	ms.storage.SetSummary(types.SeriesKey(name, labels), summary)
+	return summary, nil
+}
+
+func (ms *memStorage) Get(_ context.Context, mType, name string, labels map[string]string) (types.Metrics, error) {
+	metric := types.Metrics{
+		ID:     name,
+		MType:  mType,
+		Labels: labels,
+	}
+	key := types.SeriesKey(name, labels)
+	switch mType {
+	case GAUGE:
+		value, ok := ms.storage.GetGauge(key)
+		if !ok {
+			return metric, ErrMetricNotFound
+		}
+		metric.Value = &value
+	case COUNTER:
+		delta, ok := ms.storage.GetCounter(key)
+		if !ok {
+			return metric, ErrMetricNotFound
+		}
+		metric.Delta = &delta
+	case HISTOGRAM:
+		histogram, ok := ms.storage.GetHistogram(key)
+		if !ok {
+			return metric, ErrMetricNotFound
+		}
+		metric.Histogram = &histogram
+	case SUMMARY:
+		summary, ok := ms.storage.GetSummary(key)
+		if !ok {
+			return metric, ErrMetricNotFound
+		}
+		metric.Summary = &summary
+	default:
+		return metric, ErrUnknownType
+	}
+	return metric, nil
+}
+
+// seriesMetric restores metric identity from series key. Keys which can't be
+// parsed are treated as bare names.
+func seriesMetric(mType, key string) types.Metrics {
+	name, labels, err := types.ParseSeriesKey(key)
+	if err != nil {
+		name, labels = key, nil
+	}
+	return types.Metrics{ID: name, MType: mType, Labels: labels}
+}
+
+func (ms *memStorage) List(_ context.Context, filter map[string]string) ([]types.Metrics, error) {
+	snapshot := ms.storage.Snapshot()
+	metrics := make([]types.Metrics, 0,
+		len(snapshot.Gauges)+len(snapshot.Counters)+len(snapshot.Histograms)+len(snapshot.Summaries))
+	for key, value := range snapshot.GaugeValues() {
+		metric := seriesMetric(GAUGE, key)
+		if !types.MatchLabels(metric.Labels, filter) {
+			continue
+		}
+		metric.Value = &value
+		metrics = append(metrics, metric)
+	}
+	for key, delta := range snapshot.CounterValues() {
+		metric := seriesMetric(COUNTER, key)
+		if !types.MatchLabels(metric.Labels, filter) {
+			continue
+		}
+		metric.Delta = &delta
+		metrics = append(metrics, metric)
+	}
+	for key, histogram := range snapshot.Histograms {
+		metric := seriesMetric(HISTOGRAM, key)
+		if !types.MatchLabels(metric.Labels, filter) {
+			continue
+		}
+		metric.Histogram = &histogram
+		metrics = append(metrics, metric)
+	}
+	for key, summary := range snapshot.Summaries {
+		metric := seriesMetric(SUMMARY, key)
+		if !types.MatchLabels(metric.Labels, filter) {
+			continue
+		}
+		metric.Summary = &summary
+		metrics = append(metrics, metric)
+	}
+	return metrics, nil
+}
+
+// UpdateBatch applies all metrics at once or none of them if any has unknown
+// type or histogram with other bounds.
+func (ms *memStorage) UpdateBatch(_ context.Context, metrics []types.Metrics) ([]types.Metrics, error) {
+	batch := types.Batch{
+		Gauges:     make(map[string]float64),
+		Counters:   make(map[string]int64),
+		Histograms: make(map[string]types.Histogram),
+		Summaries:  make(map[string]types.Summary),
+	}
+	for _, metric := range metrics {
+		key := types.SeriesKey(metric.ID, metric.Labels)
+		switch metric.MType {
+		case GAUGE:
+			batch.Gauges[key] = *metric.Value
+		case COUNTER:
+			batch.Counters[key] += *metric.Delta
+		case HISTOGRAM:
+			histogram, ok := batch.Histograms[key]
+			if !ok {
+				batch.Histograms[key] = metric.Histogram.Clone()
+				continue
+			}
+			err := histogram.Merge(*metric.Histogram)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", err, metric.ID)
+			}
+			batch.Histograms[key] = histogram
+		case SUMMARY:
+			batch.Summaries[key] = *metric.Summary
+		default:
+			return nil, fmt.Errorf("%w, got %s", ErrUnknownType, metric.MType)
+		}
+	}
+	totals, err := ms.storage.Apply(batch)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for key, value := range batch.Gauges {
+		ms.history.Add(GAUGE, key, types.Sample{Timestamp: now, Value: value})
+	}
+	for key, total := range totals {
+		ms.history.Add(COUNTER, key, types.Sample{Timestamp: now, Value: float64(total)})
+	}
+	return metrics, nil
+}
+
+func (ms *memStorage) QueryRange(_ context.Context, mType, name string, labels map[string]string,
+	from, to time.Time) ([]types.Sample, error) {
+	return ms.history.Range(mType, types.SeriesKey(name, labels), from, to), nil
+}
+
+func (ms *memStorage) Delete(_ context.Context, mType, name string, labels map[string]string) error {
+	key := types.SeriesKey(name, labels)
+	if !ms.storage.Delete(mType, key) {
+		return ErrMetricNotFound
+	}
+	ms.history.Delete(mType, key)
+	return nil
+}
+
+func (ms *memStorage) DeleteMatching(ctx context.Context, mType, pattern string,
+	filter map[string]string) ([]types.Metrics, error) {
+	metrics, err := ms.List(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	matched, err := matchMetrics(metrics, mType, pattern)
+	if err != nil {
+		return nil, err
+	}
+	deleted := make([]types.Metrics, 0, len(matched))
+	for _, metric := range matched {
+		err = ms.Delete(ctx, metric.MType, metric.ID, metric.Labels)
+		if errors.Is(err, ErrMetricNotFound) {
+			// Metric is already deleted by concurrent request.
+			continue
+		}
+		deleted = append(deleted, metric)
+	}
+	return deleted, nil
+}
+
+// ResetCounter records zero in history too, so rate over reset isn't
+// negative.
+func (ms *memStorage) ResetCounter(_ context.Context, name string, labels map[string]string) error {
+	key := types.SeriesKey(name, labels)
+	if !ms.storage.ResetCounter(key) {
+		return ErrMetricNotFound
+	}
+	ms.history.Add(COUNTER, key, types.Sample{Timestamp: time.Now(), Value: 0})
+	return nil
+}
+
+func (ms *memStorage) Ping(_ context.Context) error {
+	return ErrDBNotConfigured
+}
+
+func (ms *memStorage) Close() error {
+	return nil
+}
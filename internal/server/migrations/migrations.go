@@ -0,0 +1,243 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	sqlCreateMigrationsTableCmd = `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version		bigint PRIMARY KEY,
+			name		varchar(255) NOT NULL,
+			applied_at	timestamptz NOT NULL DEFAULT now()
+		);`
+	sqlSelectVersionsCmd = `SELECT version FROM schema_migrations`
+	sqlExistsVersionCmd  = `SELECT EXISTS(SELECT 1 FROM schema_migrations WHERE version = $1)`
+	sqlInsertVersionCmd  = `INSERT INTO schema_migrations(version, name) VALUES ($1, $2)`
+	sqlDeleteVersionCmd  = `DELETE FROM schema_migrations WHERE version = $1`
+	// Serializes migrations of several server replicas started at the same time.
+	sqlLockCmd = `SELECT pg_advisory_xact_lock(7218436395)`
+
+	upSuffix   = ".up.sql"
+	downSuffix = ".down.sql"
+	migrateDir = "sql"
+)
+
+//go:embed sql/*.sql
+var migrationFiles embed.FS
+
+// Migration is one schema change. Files are named <version>_<name>.up.sql
+// and <version>_<name>.down.sql.
+type Migration struct {
+	Name    string
+	Up      string
+	Down    string
+	Version int64
+}
+
+// Load reads embedded migrations ordered by version.
+func Load() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, migrateDir)
+	if err != nil {
+		return nil, fmt.Errorf("error in read migrations directory: %w", err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		fileName := entry.Name()
+		var isUp bool
+		var base string
+		switch {
+		case strings.HasSuffix(fileName, upSuffix):
+			isUp = true
+			base = strings.TrimSuffix(fileName, upSuffix)
+		case strings.HasSuffix(fileName, downSuffix):
+			base = strings.TrimSuffix(fileName, downSuffix)
+		default:
+			continue
+		}
+
+		versionStr, name, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration file name must be like <version>_<name>, got %s", fileName)
+		}
+		version, err := strconv.ParseInt(versionStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("error in parse version of migration %s: %w", fileName, err)
+		}
+		data, err := migrationFiles.ReadFile(path.Join(migrateDir, fileName))
+		if err != nil {
+			return nil, fmt.Errorf("error in read migration %s: %w", fileName, err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		}
+		if isUp {
+			migration.Up = string(data)
+		} else {
+			migration.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up step", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Up applies all not yet applied migrations, each in its own transaction.
+func Up(ctx context.Context, db *sql.DB) error {
+	migrations, err := Load()
+	if err != nil {
+		return err
+	}
+	applied, err := appliedVersions(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		if applied[migration.Version] {
+			continue
+		}
+		err = inTx(ctx, db, func(tx *sql.Tx) error {
+			var exists bool
+			err := tx.QueryRowContext(ctx, sqlExistsVersionCmd, migration.Version).Scan(&exists)
+			if err != nil {
+				return fmt.Errorf("error in check migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			if exists {
+				return nil
+			}
+			_, err = tx.ExecContext(ctx, migration.Up)
+			if err != nil {
+				return fmt.Errorf("error in apply migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			_, err = tx.ExecContext(ctx, sqlInsertVersionCmd, migration.Version, migration.Name)
+			if err != nil {
+				return fmt.Errorf("error in record migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Down rolls back the last steps applied migrations.
+func Down(ctx context.Context, db *sql.DB, steps int) error {
+	migrations, err := Load()
+	if err != nil {
+		return err
+	}
+	applied, err := appliedVersions(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		migration := migrations[i]
+		if !applied[migration.Version] {
+			continue
+		}
+		if migration.Down == "" {
+			return fmt.Errorf("migration %d_%s has no down step", migration.Version, migration.Name)
+		}
+		err = inTx(ctx, db, func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, migration.Down)
+			if err != nil {
+				return fmt.Errorf("error in roll back migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			_, err = tx.ExecContext(ctx, sqlDeleteVersionCmd, migration.Version)
+			if err != nil {
+				return fmt.Errorf("error in delete record of migration %d_%s: %w",
+					migration.Version, migration.Name, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		steps--
+	}
+	return nil
+}
+
+func appliedVersions(ctx context.Context, db *sql.DB) (map[int64]bool, error) {
+	_, err := db.ExecContext(ctx, sqlCreateMigrationsTableCmd)
+	if err != nil {
+		return nil, fmt.Errorf("error in create schema_migrations table: %w", err)
+	}
+
+	rows, err := db.QueryContext(ctx, sqlSelectVersionsCmd)
+	if err != nil {
+		return nil, fmt.Errorf("error in select applied migrations: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	applied := make(map[int64]bool)
+	for rows.Next() {
+		var version int64
+		err = rows.Scan(&version)
+		if err != nil {
+			return nil, fmt.Errorf("error in scan applied migration: %w", err)
+		}
+		applied[version] = true
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error in iterate applied migrations: %w", err)
+	}
+	return applied, nil
+}
+
+// inTx runs fn in a transaction holding the migrations advisory lock, so
+// replicas started at the same time don't apply one migration twice.
+func inTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) (err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error in create transaction for migration: %w", err)
+	}
+	defer func() {
+		rollbackErr := tx.Rollback()
+		if rollbackErr != nil && !errors.Is(rollbackErr, sql.ErrTxDone) {
+			err = rollbackErr
+		}
+	}()
+
+	_, err = tx.ExecContext(ctx, sqlLockCmd)
+	if err != nil {
+		return fmt.Errorf("error in lock migrations: %w", err)
+	}
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error in commit migration: %w", err)
+	}
+	return nil
+}
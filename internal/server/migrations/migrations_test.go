@@ -0,0 +1,103 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	for i, migration := range migrations {
+		assert.NotEmpty(t, migration.Up, "migration %d has no up step", migration.Version)
+		assert.NotEmpty(t, migration.Down, "migration %d has no down step", migration.Version)
+		if i > 0 {
+			assert.Less(t, migrations[i-1].Version, migration.Version)
+		}
+	}
+	assert.Equal(t, "create_metric_tables", migrations[0].Name)
+}
+
+// openTestDB connects to PostgreSQL from DATABASE_DSN in a new schema, which
+// is dropped after test, so tables of data base are not touched.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("DATABASE_DSN is not set")
+	}
+	admin, err := sql.Open("pgx", dsn)
+	require.NoError(t, err)
+	schema := fmt.Sprintf("migrations_test_%d", time.Now().UnixNano())
+	_, err = admin.Exec("CREATE SCHEMA " + schema)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_, _ = admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		_ = admin.Close()
+	})
+
+	config, err := pgx.ParseConfig(dsn)
+	require.NoError(t, err)
+	config.RuntimeParams["search_path"] = schema
+	db := stdlib.OpenDB(*config)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return db
+}
+
+func tableExists(t *testing.T, db *sql.DB, table string) bool {
+	t.Helper()
+	var name sql.NullString
+	require.NoError(t, db.QueryRow("SELECT to_regclass($1)::text", table).Scan(&name))
+	return name.Valid
+}
+
+func TestUpDownUp(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	migrations, err := Load()
+	require.NoError(t, err)
+	tables := []string{"gauges", "counters", "samples", "idempotency_keys", "histograms", "summaries"}
+
+	require.NoError(t, Up(ctx, db))
+	for _, table := range tables {
+		assert.True(t, tableExists(t, db, table), "table %s is created", table)
+	}
+	applied, err := appliedVersions(ctx, db)
+	require.NoError(t, err)
+	assert.Len(t, applied, len(migrations))
+	require.NoError(t, Up(ctx, db), "applied migrations are skipped")
+
+	require.NoError(t, Down(ctx, db, 1))
+	applied, err = appliedVersions(ctx, db)
+	require.NoError(t, err)
+	assert.Len(t, applied, len(migrations)-1)
+	assert.False(t, tableExists(t, db, "histograms"), "the last migration is rolled back")
+
+	require.NoError(t, Down(ctx, db, len(migrations)))
+	for _, table := range tables {
+		assert.False(t, tableExists(t, db, table), "table %s is dropped", table)
+	}
+	applied, err = appliedVersions(ctx, db)
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+
+	require.NoError(t, Up(ctx, db))
+	_, err = db.Exec(`INSERT INTO counters(metric_name, labels, value) VALUES ('PollCount', '', 5000000000)`)
+	require.NoError(t, err, "counters are bigint after migrations are applied again")
+	var value int64
+	require.NoError(t, db.QueryRow(`SELECT value FROM counters WHERE metric_name = 'PollCount'`).Scan(&value))
+	assert.Equal(t, int64(5000000000), value)
+}
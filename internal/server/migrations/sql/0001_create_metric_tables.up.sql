@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS gauges (
+	metric_name		varchar(100) PRIMARY KEY,
+	value			double precision NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS counters (
+	metric_name		varchar(100) PRIMARY KEY,
+	value			integer NOT NULL
+);
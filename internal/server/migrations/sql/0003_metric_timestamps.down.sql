@@ -0,0 +1,7 @@
+ALTER TABLE counters
+	DROP COLUMN IF EXISTS updated_at,
+	DROP COLUMN IF EXISTS created_at;
+
+ALTER TABLE gauges
+	DROP COLUMN IF EXISTS updated_at,
+	DROP COLUMN IF EXISTS created_at;
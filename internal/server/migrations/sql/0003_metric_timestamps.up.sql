@@ -0,0 +1,7 @@
+ALTER TABLE gauges
+	ADD COLUMN IF NOT EXISTS created_at timestamptz NOT NULL DEFAULT now(),
+	ADD COLUMN IF NOT EXISTS updated_at timestamptz NOT NULL DEFAULT now();
+
+ALTER TABLE counters
+	ADD COLUMN IF NOT EXISTS created_at timestamptz NOT NULL DEFAULT now(),
+	ADD COLUMN IF NOT EXISTS updated_at timestamptz NOT NULL DEFAULT now();
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS samples (
+	id				bigserial PRIMARY KEY,
+	metric_type		varchar(16) NOT NULL,
+	metric_name		varchar(100) NOT NULL,
+	value			double precision NOT NULL,
+	created_at		timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS samples_metric_created_at_idx ON samples (metric_type, metric_name, created_at);
@@ -0,0 +1,14 @@
+DROP INDEX IF EXISTS samples_series_created_at_idx;
+DELETE FROM samples WHERE labels <> '';
+ALTER TABLE samples DROP COLUMN IF EXISTS labels;
+CREATE INDEX IF NOT EXISTS samples_metric_created_at_idx ON samples (metric_type, metric_name, created_at);
+
+DELETE FROM counters WHERE labels <> '';
+ALTER TABLE counters DROP CONSTRAINT IF EXISTS counters_pkey;
+ALTER TABLE counters DROP COLUMN IF EXISTS labels;
+ALTER TABLE counters ADD PRIMARY KEY (metric_name);
+
+DELETE FROM gauges WHERE labels <> '';
+ALTER TABLE gauges DROP CONSTRAINT IF EXISTS gauges_pkey;
+ALTER TABLE gauges DROP COLUMN IF EXISTS labels;
+ALTER TABLE gauges ADD PRIMARY KEY (metric_name);
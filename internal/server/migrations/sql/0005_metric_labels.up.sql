@@ -0,0 +1,11 @@
+ALTER TABLE gauges ADD COLUMN IF NOT EXISTS labels text NOT NULL DEFAULT '';
+ALTER TABLE gauges DROP CONSTRAINT IF EXISTS gauges_pkey;
+ALTER TABLE gauges ADD PRIMARY KEY (metric_name, labels);
+
+ALTER TABLE counters ADD COLUMN IF NOT EXISTS labels text NOT NULL DEFAULT '';
+ALTER TABLE counters DROP CONSTRAINT IF EXISTS counters_pkey;
+ALTER TABLE counters ADD PRIMARY KEY (metric_name, labels);
+
+ALTER TABLE samples ADD COLUMN IF NOT EXISTS labels text NOT NULL DEFAULT '';
+DROP INDEX IF EXISTS samples_metric_created_at_idx;
+CREATE INDEX IF NOT EXISTS samples_series_created_at_idx ON samples (metric_type, metric_name, labels, created_at);
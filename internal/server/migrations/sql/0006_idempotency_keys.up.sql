@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	key				varchar(100) PRIMARY KEY,
+	request_hash	varchar(64) NOT NULL,
+	status			integer NOT NULL,
+	content_type	varchar(100) NOT NULL,
+	body			bytea NOT NULL,
+	expires_at		timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS histograms (
+	metric_name		varchar(100) NOT NULL,
+	labels			text NOT NULL DEFAULT '',
+	bounds			double precision[] NOT NULL,
+	counts			bigint[] NOT NULL,
+	sum				double precision NOT NULL,
+	created_at		timestamptz NOT NULL DEFAULT now(),
+	updated_at		timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY (metric_name, labels)
+);
+
+CREATE TABLE IF NOT EXISTS summaries (
+	metric_name		varchar(100) NOT NULL,
+	labels			text NOT NULL DEFAULT '',
+	quantiles		double precision[] NOT NULL,
+	quantile_values	double precision[] NOT NULL,
+	sum				double precision NOT NULL,
+	count			bigint NOT NULL,
+	created_at		timestamptz NOT NULL DEFAULT now(),
+	updated_at		timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY (metric_name, labels)
+);
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xChygyNx/metrical/internal/server/types"
+)
+
+const (
+	acceptHeader           = "Accept"
+	openMetricsMediaType   = "application/openmetrics-text"
+	openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	prometheusContentType  = "text/plain; version=0.0.4; charset=utf-8"
+	openMetricsTotalSuffix = "_total"
+)
+
+// sanitizeMetricName maps metric name to Prometheus charset [a-zA-Z_:][a-zA-Z0-9_:]*.
+func sanitizeMetricName(name string) string {
+	var builder strings.Builder
+	builder.Grow(len(name) + 1)
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':':
+			builder.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				builder.WriteByte('_')
+			}
+			builder.WriteRune(r)
+		default:
+			builder.WriteByte('_')
+		}
+	}
+	if builder.Len() == 0 {
+		return "_"
+	}
+	return builder.String()
+}
+
+func formatPrometheusFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+// acceptsOpenMetrics reports whether scraper asked for OpenMetrics format.
+func acceptsOpenMetrics(headers http.Header) bool {
+	for _, value := range headers.Values(acceptHeader) {
+		if strings.Contains(value, openMetricsMediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// exposedSample is one line of metric in exposition. Suffix is added to name
+// of metric family and label to labels of metric.
+type exposedSample struct {
+	suffix string
+	label  string
+	value  string
+}
+
+// histogramSamples returns cumulative buckets, sum and count of histogram.
+func histogramSamples(histogram *types.Histogram) []exposedSample {
+	samples := make([]exposedSample, 0, len(histogram.Counts)+2)
+	var cumulative int64
+	for i, count := range histogram.Counts {
+		cumulative += count
+		bound := math.Inf(1)
+		if i < len(histogram.Bounds) {
+			bound = histogram.Bounds[i]
+		}
+		samples = append(samples, exposedSample{
+			suffix: "_bucket",
+			label:  `le="` + formatPrometheusFloat(bound) + `"`,
+			value:  strconv.FormatInt(cumulative, 10),
+		})
+	}
+	return append(samples,
+		exposedSample{suffix: "_sum", value: formatPrometheusFloat(histogram.Sum)},
+		exposedSample{suffix: "_count", value: strconv.FormatInt(cumulative, 10)})
+}
+
+// summarySamples returns quantiles, sum and count of summary.
+func summarySamples(summary *types.Summary) []exposedSample {
+	samples := make([]exposedSample, 0, len(summary.Quantiles)+2)
+	for _, quantile := range summary.Quantiles {
+		samples = append(samples, exposedSample{
+			label: `quantile="` + formatPrometheusFloat(quantile.Quantile) + `"`,
+			value: formatPrometheusFloat(quantile.Value),
+		})
+	}
+	return append(samples,
+		exposedSample{suffix: "_sum", value: formatPrometheusFloat(summary.Sum)},
+		exposedSample{suffix: "_count", value: strconv.FormatInt(summary.Count, 10)})
+}
+
+// renderPrometheus writes metrics in Prometheus text format 0.0.4 or, if
+// openMetrics is set, in OpenMetrics 1.0.0 format. Series of one name are
+// grouped under one TYPE line. Metrics whose sanitized names are already used
+// by metric of other type or with other original name, like a.b and a_b, are
+// skipped.
+func renderPrometheus(metrics []types.Metrics, openMetrics bool) []byte {
+	type exposed struct {
+		family  string
+		name    string
+		labels  string
+		mType   string
+		samples []exposedSample
+	}
+	series := make([]exposed, 0, len(metrics))
+	for _, metric := range metrics {
+		item := exposed{
+			family: sanitizeMetricName(metric.ID),
+			name:   metric.ID,
+			labels: types.FormatLabels(metric.Labels),
+			mType:  metric.MType,
+		}
+		switch {
+		case metric.MType == GAUGE && metric.Value != nil:
+			item.samples = []exposedSample{{value: formatPrometheusFloat(*metric.Value)}}
+		case metric.MType == COUNTER && metric.Delta != nil:
+			sample := exposedSample{value: strconv.FormatInt(*metric.Delta, 10)}
+			if openMetrics {
+				item.family = strings.TrimSuffix(item.family, openMetricsTotalSuffix)
+				sample.suffix = openMetricsTotalSuffix
+			}
+			item.samples = []exposedSample{sample}
+		case metric.MType == HISTOGRAM && metric.Histogram != nil:
+			item.samples = histogramSamples(metric.Histogram)
+		case metric.MType == SUMMARY && metric.Summary != nil:
+			item.samples = summarySamples(metric.Summary)
+		default:
+			continue
+		}
+		series = append(series, item)
+	}
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].family != series[j].family {
+			return series[i].family < series[j].family
+		}
+		if series[i].mType != series[j].mType {
+			return series[i].mType < series[j].mType
+		}
+		if series[i].name != series[j].name {
+			return series[i].name < series[j].name
+		}
+		return series[i].labels < series[j].labels
+	})
+
+	var buf bytes.Buffer
+	// Family belongs to the first metric exposed under its name.
+	owners := make(map[string]exposed, len(series))
+	for _, item := range series {
+		owner, ok := owners[item.family]
+		if ok && owner.mType != item.mType {
+			log.Printf("skip %s metric in exposition: name %s is already used by %s\n",
+				item.mType, item.family, owner.mType)
+			continue
+		}
+		if ok && owner.name != item.name {
+			log.Printf("skip %s metric %s in exposition: name %s is already used by metric %s\n",
+				item.mType, item.name, item.family, owner.name)
+			continue
+		}
+		if !ok {
+			owners[item.family] = item
+			fmt.Fprintf(&buf, "# TYPE %s %s\n", item.family, item.mType)
+		}
+
+		for _, sample := range item.samples {
+			sampleName := item.family + sample.suffix
+			labels := item.labels
+			if labels != "" && sample.label != "" {
+				labels += ","
+			}
+			labels += sample.label
+			if labels != "" {
+				sampleName += "{" + labels + "}"
+			}
+			fmt.Fprintf(&buf, "%s %s\n", sampleName, sample.value)
+		}
+	}
+	if openMetrics {
+		buf.WriteString("# EOF\n")
+	}
+	return buf.Bytes()
+}
+
+func PrometheusHandle(storage Storage) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		filter, err := parseLabelParams(req)
+		if err != nil {
+			writeBadRequest(res, err)
+			return
+		}
+		metrics, err := storage.List(req.Context(), filter)
+		if err != nil {
+			log.Println(err)
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
+			return
+		}
+
+		openMetrics := acceptsOpenMetrics(req.Header)
+		if openMetrics {
+			res.Header().Set(contentType, openMetricsContentType)
+		} else {
+			res.Header().Set(contentType, prometheusContentType)
+		}
+
+		res.WriteHeader(http.StatusOK)
+		_, err = res.Write(renderPrometheus(metrics, openMetrics))
+		if err != nil {
+			errorMsg := fmt.Errorf(errorMsgWildcard, writeHandlerErrorMsg, err).Error()
+			log.Println(errorMsg)
+			return
+		}
+	}
+}
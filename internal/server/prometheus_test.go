@@ -0,0 +1,100 @@
+package server
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xChygyNx/metrical/internal/server/types"
+)
+
+func TestSanitizeMetricName(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric string
+		want   string
+	}{
+		{
+			name:   "Valid name",
+			metric: "HeapAlloc",
+			want:   "HeapAlloc",
+		},
+		{
+			name:   "Name with forbidden chars",
+			metric: "cpu.usage-percent 1",
+			want:   "cpu_usage_percent_1",
+		},
+		{
+			name:   "Name starts with digit",
+			metric: "1min",
+			want:   "_1min",
+		},
+		{
+			name:   "Empty name",
+			metric: "",
+			want:   "_",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, sanitizeMetricName(test.metric))
+		})
+	}
+}
+
+func TestRenderPrometheus(t *testing.T) {
+	alloc := 1.5
+	inf := math.Inf(1)
+	var pollCount int64 = 7
+	metrics := func() []types.Metrics {
+		return []types.Metrics{
+			{ID: "PollCount", MType: COUNTER, Delta: &pollCount},
+			{ID: "Alloc", MType: GAUGE, Value: &alloc},
+			{ID: "Heap.Max", MType: GAUGE, Value: &inf},
+			{ID: "Heap_Max", MType: GAUGE, Value: &alloc},
+			{ID: "Alloc", MType: GAUGE, Value: &alloc, Labels: map[string]string{"host": "web \"1\""}},
+			{
+				ID: "latency", MType: HISTOGRAM, Labels: map[string]string{"path": "/"},
+				Histogram: &types.Histogram{Bounds: []float64{0.1, 1}, Counts: []int64{1, 2, 3}, Sum: 4.5},
+			},
+			{
+				ID: "duration", MType: SUMMARY,
+				Summary: &types.Summary{Quantiles: []types.Quantile{{Quantile: 0.5, Value: 0.2}}, Sum: 3, Count: 10},
+			},
+		}
+	}
+	tests := []struct {
+		name        string
+		want        string
+		openMetrics bool
+	}{
+		{
+			name: "Prometheus text format",
+			want: "# TYPE Alloc gauge\nAlloc 1.5\nAlloc{host=\"web \\\"1\\\"\"} 1.5\n" +
+				"# TYPE Heap_Max gauge\nHeap_Max +Inf\n" +
+				"# TYPE PollCount counter\nPollCount 7\n" +
+				"# TYPE duration summary\nduration{quantile=\"0.5\"} 0.2\nduration_sum 3\nduration_count 10\n" +
+				"# TYPE latency histogram\nlatency_bucket{path=\"/\",le=\"0.1\"} 1\n" +
+				"latency_bucket{path=\"/\",le=\"1\"} 3\nlatency_bucket{path=\"/\",le=\"+Inf\"} 6\n" +
+				"latency_sum{path=\"/\"} 4.5\nlatency_count{path=\"/\"} 6\n",
+		},
+		{
+			name: "OpenMetrics format",
+			want: "# TYPE Alloc gauge\nAlloc 1.5\nAlloc{host=\"web \\\"1\\\"\"} 1.5\n" +
+				"# TYPE Heap_Max gauge\nHeap_Max +Inf\n" +
+				"# TYPE PollCount counter\nPollCount_total 7\n" +
+				"# TYPE duration summary\nduration{quantile=\"0.5\"} 0.2\nduration_sum 3\nduration_count 10\n" +
+				"# TYPE latency histogram\nlatency_bucket{path=\"/\",le=\"0.1\"} 1\n" +
+				"latency_bucket{path=\"/\",le=\"1\"} 3\nlatency_bucket{path=\"/\",le=\"+Inf\"} 6\n" +
+				"latency_sum{path=\"/\"} 4.5\nlatency_count{path=\"/\"} 6\n" +
+				"# EOF\n",
+			openMetrics: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, string(renderPrometheus(metrics(), test.openMetrics)))
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/xChygyNx/metrical/internal/server/types"
+)
+
+const (
+	defaultQueryWindow = time.Hour
+	maxQueryPoints     = 11000
+	nanosecondsInSec   = 1e9
+)
+
+// parseQueryTime accepts unix timestamp in seconds (fractions allowed) or
+// RFC3339 time. Empty value means def.
+func parseQueryTime(value string, def time.Time) (time.Time, error) {
+	if value == "" {
+		return def, nil
+	}
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err == nil {
+		whole, frac := math.Modf(seconds)
+		return time.Unix(int64(whole), int64(frac*nanosecondsInSec)), nil
+	}
+	moment, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("time must be unix timestamp or RFC3339, got %s: %w", value, err)
+	}
+	return moment, nil
+}
+
+// parseQueryStep accepts duration like 15s or number of seconds. Empty value
+// means raw samples without downsampling.
+func parseQueryStep(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err == nil {
+		return time.Duration(seconds * nanosecondsInSec), nil
+	}
+	step, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("step must be duration or number of seconds, got %s: %w", value, err)
+	}
+	return step, nil
+}
+
+type rangeQuery struct {
+	from   time.Time
+	to     time.Time
+	labels map[string]string
+	id     string
+	mType  string
+	step   time.Duration
+}
+
+func parseRangeQuery(req *http.Request) (rangeQuery, error) {
+	params := req.URL.Query()
+	query := rangeQuery{
+		id:    params.Get("id"),
+		mType: params.Get("type"),
+	}
+	err := types.ValidateName(query.id)
+	if err != nil {
+		return query, err
+	}
+	err = types.ValidateType(query.mType)
+	if err != nil {
+		return query, err
+	}
+
+	query.labels, err = parseLabelParams(req)
+	if err != nil {
+		return query, err
+	}
+	query.to, err = parseQueryTime(params.Get("to"), time.Now())
+	if err != nil {
+		return query, &types.ValidationError{Field: "to", Message: err.Error()}
+	}
+	query.from, err = parseQueryTime(params.Get("from"), query.to.Add(-defaultQueryWindow))
+	if err != nil {
+		return query, &types.ValidationError{Field: "from", Message: err.Error()}
+	}
+	if query.from.After(query.to) {
+		return query, &types.ValidationError{Field: "from", Message: "must not be after to"}
+	}
+	query.step, err = parseQueryStep(params.Get("step"))
+	if err != nil {
+		return query, &types.ValidationError{Field: "step", Message: err.Error()}
+	}
+	if query.step < 0 {
+		return query, &types.ValidationError{Field: "step", Message: "must be positive"}
+	}
+	if query.step > 0 && query.to.Sub(query.from)/query.step > maxQueryPoints {
+		errorMsg := fmt.Sprintf("query exceeds %d points, increase step", maxQueryPoints)
+		return query, &types.ValidationError{Field: "step", Message: errorMsg}
+	}
+	return query, nil
+}
+
+func QueryRangeHandle(storage Storage) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set(contentType, jsonContentType)
+
+		query, err := parseRangeQuery(req)
+		if err != nil {
+			writeBadRequest(res, err)
+			return
+		}
+
+		_, err = storage.Get(req.Context(), query.mType, query.id, query.labels)
+		if errors.Is(err, ErrMetricNotFound) {
+			writeError(res, "Metric "+query.id+" not set", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Println(err)
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
+			return
+		}
+
+		samples, err := storage.QueryRange(req.Context(), query.mType, query.id, query.labels, query.from, query.to)
+		if err != nil {
+			log.Println(err)
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
+			return
+		}
+
+		if query.step == 0 && len(samples) > maxQueryPoints {
+			// Raw samples aren't downsampled, so only the latest ones are returned.
+			samples = samples[len(samples)-maxQueryPoints:]
+		}
+		result := types.RangeResult{
+			ID:     query.id,
+			MType:  query.mType,
+			Labels: query.labels,
+			Points: types.Downsample(samples, query.from, query.to, query.step),
+		}
+		responseData, err := json.Marshal(result)
+		if err != nil {
+			errorMsg := fmt.Errorf("error in serialize response for send by server: %w", err).Error()
+			log.Println(errorMsg)
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
+			return
+		}
+
+		res.WriteHeader(http.StatusOK)
+		_, err = res.Write(responseData)
+		if err != nil {
+			errorMsg := fmt.Errorf(errorMsgWildcard, writeHandlerErrorMsg, err).Error()
+			log.Println(errorMsg)
+			return
+		}
+	}
+}
+
+// aggregateWindow resolves time window of aggregation query.
+func aggregateWindow(aggReq *types.AggregateRequest) (from, to time.Time, err error) {
+	to = time.Now()
+	if aggReq.To != nil {
+		to = *aggReq.To
+	}
+	if aggReq.From != nil {
+		from = *aggReq.From
+	} else {
+		window := defaultQueryWindow
+		if aggReq.Window != "" {
+			window, err = time.ParseDuration(aggReq.Window)
+			if err != nil {
+				return from, to, &types.ValidationError{Field: "window", Message: err.Error()}
+			}
+		}
+		from = to.Add(-window)
+	}
+	if from.After(to) {
+		return from, to, &types.ValidationError{Field: "from", Message: "must not be after to"}
+	}
+	return from, to, nil
+}
+
+func AggregateHandle(storage Storage) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set(contentType, jsonContentType)
+
+		var aggReq types.AggregateRequest
+		err := json.NewDecoder(req.Body).Decode(&aggReq)
+		if err != nil {
+			writeError(res, "error in decode request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = types.ValidateName(aggReq.ID)
+		if err == nil {
+			err = types.ValidateType(aggReq.MType)
+		}
+		if err != nil {
+			writeBadRequest(res, err)
+			return
+		}
+		fn, quantile, err := types.ResolveAggregation(aggReq.Func, aggReq.Quantile)
+		if errors.Is(err, types.ErrBadQuantile) {
+			writeFieldError(res, err.Error(), "quantile", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			writeFieldError(res, err.Error(), "func", http.StatusBadRequest)
+			return
+		}
+		if fn == types.AggRate && aggReq.MType != COUNTER {
+			writeFieldError(res, "rate is defined only for counter metrics", "func", http.StatusBadRequest)
+			return
+		}
+		if fn == types.AggQuantile && aggReq.MType != GAUGE {
+			writeFieldError(res, "quantile is defined only for gauge metrics", "func", http.StatusBadRequest)
+			return
+		}
+		err = types.ValidateLabels(aggReq.Labels)
+		if err != nil {
+			writeFieldError(res, err.Error(), "labels", http.StatusBadRequest)
+			return
+		}
+		from, to, err := aggregateWindow(&aggReq)
+		if err != nil {
+			writeBadRequest(res, err)
+			return
+		}
+
+		_, err = storage.Get(req.Context(), aggReq.MType, aggReq.ID, aggReq.Labels)
+		if errors.Is(err, ErrMetricNotFound) {
+			writeError(res, "Metric "+aggReq.ID+" not set", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Println(err)
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
+			return
+		}
+
+		samples, err := storage.QueryRange(req.Context(), aggReq.MType, aggReq.ID, aggReq.Labels, from, to)
+		if err != nil {
+			log.Println(err)
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
+			return
+		}
+
+		result := types.AggregateResult{
+			ID:       aggReq.ID,
+			MType:    aggReq.MType,
+			Labels:   aggReq.Labels,
+			Func:     fn,
+			Quantile: quantile,
+			From:     from,
+			To:       to,
+			Count:    len(samples),
+		}
+		var q float64
+		if quantile != nil {
+			q = *quantile
+		}
+		if value, ok := types.Aggregate(samples, fn, q); ok {
+			result.Value = &value
+		}
+
+		responseData, err := json.Marshal(result)
+		if err != nil {
+			errorMsg := fmt.Errorf("error in serialize response for send by server: %w", err).Error()
+			log.Println(errorMsg)
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
+			return
+		}
+
+		res.WriteHeader(http.StatusOK)
+		_, err = res.Write(responseData)
+		if err != nil {
+			errorMsg := fmt.Errorf(errorMsgWildcard, writeHandlerErrorMsg, err).Error()
+			log.Println(errorMsg)
+			return
+		}
+	}
+}
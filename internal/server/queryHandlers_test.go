@@ -0,0 +1,265 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xChygyNx/metrical/internal/server/types"
+)
+
+func floatPtr(value float64) *float64 {
+	return &value
+}
+
+// newQueryRouter serves storage with gauge Alloc of values 1, 2, 3, 4 and
+// counter PollCount.
+func newQueryRouter(t *testing.T) http.Handler {
+	t.Helper()
+	storage := newMemStorage(defaultHistorySize)
+	for _, value := range []float64{1, 2, 3, 4} {
+		_, err := storage.UpdateGauge(context.Background(), "Alloc", nil, value)
+		require.NoError(t, err)
+		_, err = storage.AddCounter(context.Background(), "PollCount", nil, 1)
+		require.NoError(t, err)
+	}
+	return newTestRouter(storage)
+}
+
+func TestQueryRangeHandle(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		field  string
+		status int
+		points int
+	}{
+		{
+			name:   "Raw samples of default window",
+			query:  "id=Alloc&type=gauge",
+			status: http.StatusOK,
+			points: 4,
+		},
+		{
+			name:   "Downsampled samples",
+			query:  "id=Alloc&type=gauge&step=1h",
+			status: http.StatusOK,
+			points: 1,
+		},
+		{
+			name:   "Window before samples",
+			query:  "id=Alloc&type=gauge&from=0&to=100",
+			status: http.StatusOK,
+		},
+		{
+			name:   "From after to",
+			query:  "id=Alloc&type=gauge&from=200&to=100",
+			status: http.StatusBadRequest,
+			field:  "from",
+		},
+		{
+			name:   "Bad time",
+			query:  "id=Alloc&type=gauge&to=yesterday",
+			status: http.StatusBadRequest,
+			field:  "to",
+		},
+		{
+			name:   "Negative step",
+			query:  "id=Alloc&type=gauge&step=-1s",
+			status: http.StatusBadRequest,
+			field:  "step",
+		},
+		{
+			name:   "Too many points",
+			query:  "id=Alloc&type=gauge&from=0&to=100000&step=1",
+			status: http.StatusBadRequest,
+			field:  "step",
+		},
+		{
+			name:   "Bad label",
+			query:  "id=Alloc&type=gauge&label=host",
+			status: http.StatusBadRequest,
+			field:  "label",
+		},
+		{
+			name:   "Not saved metric",
+			query:  "id=HeapSys&type=gauge",
+			status: http.StatusNotFound,
+		},
+	}
+	router := newQueryRouter(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/query_range?"+test.query, http.NoBody)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			require.Equal(t, test.status, rec.Code)
+			if test.status != http.StatusOK {
+				var body types.ErrorResponse
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+				assert.Equal(t, test.field, body.Field)
+				return
+			}
+			var result types.RangeResult
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+			assert.Len(t, result.Points, test.points)
+		})
+	}
+}
+
+func TestAggregateHandle(t *testing.T) {
+	tests := []struct {
+		value    *float64
+		quantile *float64
+		name     string
+		body     string
+		field    string
+		fn       string
+		window   time.Duration
+		status   int
+		empty    bool
+	}{
+		{
+			name:   "Default window",
+			body:   `{"id":"Alloc","type":"gauge","func":"max"}`,
+			status: http.StatusOK,
+			fn:     types.AggMax,
+			value:  floatPtr(4.0),
+			window: defaultQueryWindow,
+		},
+		{
+			name:   "Custom window",
+			body:   `{"id":"Alloc","type":"gauge","func":"avg","window":"30m"}`,
+			status: http.StatusOK,
+			fn:     types.AggAvg,
+			value:  floatPtr(2.5),
+			window: 30 * time.Minute,
+		},
+		{
+			name:     "Quantile alias",
+			body:     `{"id":"Alloc","type":"gauge","func":"p50"}`,
+			status:   http.StatusOK,
+			fn:       types.AggQuantile,
+			quantile: floatPtr(0.5),
+			window:   defaultQueryWindow,
+		},
+		{
+			name:     "Explicit quantile",
+			body:     `{"id":"Alloc","type":"gauge","func":"quantile","quantile":1}`,
+			status:   http.StatusOK,
+			fn:       types.AggQuantile,
+			quantile: floatPtr(1.0),
+			value:    floatPtr(4.0),
+			window:   defaultQueryWindow,
+		},
+		{
+			name:   "Window before samples",
+			body:   `{"id":"Alloc","type":"gauge","func":"max","from":"2020-01-01T00:00:00Z","to":"2020-01-02T00:00:00Z"}`,
+			status: http.StatusOK,
+			fn:     types.AggMax,
+			window: 24 * time.Hour,
+			empty:  true,
+		},
+		{
+			name:   "Quantile out of range",
+			body:   `{"id":"Alloc","type":"gauge","func":"quantile","quantile":2}`,
+			status: http.StatusBadRequest,
+			field:  "quantile",
+		},
+		{
+			name:   "Unknown function",
+			body:   `{"id":"Alloc","type":"gauge","func":"median"}`,
+			status: http.StatusBadRequest,
+			field:  "func",
+		},
+		{
+			name:   "Rate of gauge",
+			body:   `{"id":"Alloc","type":"gauge","func":"rate"}`,
+			status: http.StatusBadRequest,
+			field:  "func",
+		},
+		{
+			name:   "Quantile of counter",
+			body:   `{"id":"PollCount","type":"counter","func":"p99"}`,
+			status: http.StatusBadRequest,
+			field:  "func",
+		},
+		{
+			name:   "From after to",
+			body:   `{"id":"Alloc","type":"gauge","func":"max","from":"2020-01-02T00:00:00Z","to":"2020-01-01T00:00:00Z"}`,
+			status: http.StatusBadRequest,
+			field:  "from",
+		},
+		{
+			name:   "Bad window",
+			body:   `{"id":"Alloc","type":"gauge","func":"max","window":"hour"}`,
+			status: http.StatusBadRequest,
+			field:  "window",
+		},
+		{
+			name:   "Bad labels",
+			body:   `{"id":"Alloc","type":"gauge","func":"max","labels":{"1host":"web1"}}`,
+			status: http.StatusBadRequest,
+			field:  "labels",
+		},
+		{
+			name:   "Not saved metric",
+			body:   `{"id":"HeapSys","type":"gauge","func":"max"}`,
+			status: http.StatusNotFound,
+		},
+	}
+	router := newQueryRouter(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/value/aggregate", strings.NewReader(test.body))
+			req.Header.Set(contentType, jsonContentType)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			require.Equal(t, test.status, rec.Code)
+			if test.status != http.StatusOK {
+				var body types.ErrorResponse
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+				assert.Equal(t, test.field, body.Field)
+				return
+			}
+			var result types.AggregateResult
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+			assert.Equal(t, test.fn, result.Func)
+			assert.Equal(t, test.quantile, result.Quantile)
+			assert.Equal(t, test.window, result.To.Sub(result.From))
+			if test.empty {
+				assert.Nil(t, result.Value, "window without samples has no value")
+				return
+			}
+			require.NotNil(t, result.Value)
+			if test.value != nil {
+				assert.InDelta(t, *test.value, *result.Value, 0)
+			}
+		})
+	}
+}
+
+func TestRawRangeQueryIsCapped(t *testing.T) {
+	storage := newMemStorage(maxQueryPoints + 10)
+	for i := range maxQueryPoints + 10 {
+		_, err := storage.UpdateGauge(context.Background(), "Alloc", nil, float64(i))
+		require.NoError(t, err)
+	}
+	router := newTestRouter(storage)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/query_range?id=Alloc&type=gauge", http.NoBody)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var result types.RangeResult
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	require.Len(t, result.Points, maxQueryPoints)
+	assert.InDelta(t, float64(maxQueryPoints+9), result.Points[maxQueryPoints-1].Value, 0, "the latest samples are kept")
+}
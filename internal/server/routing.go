@@ -1,18 +1,28 @@
 package server
 
 import (
+	"context"
+	"crypto/rsa"
 	"errors"
 	"fmt"
-	"io/fs"
+	"net"
 	"net/http"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
 
 	"github.com/xChygyNx/metrical/internal/server/types"
 )
 
+const (
+	readHeaderTimeout = 10 * time.Second
+	shutdownTimeout   = 10 * time.Second
+)
+
 func middlewareLogger(h http.Handler, sugar zap.SugaredLogger) http.HandlerFunc {
 	logFn := func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -44,6 +54,49 @@ func middlewareLogger(h http.Handler, sugar zap.SugaredLogger) http.HandlerFunc
 	return logFn
 }
 
+func newRouter(config *Config, storage Storage, agents *types.AgentRegistry, sugar zap.SugaredLogger) *chi.Mux {
+	router := chi.NewRouter()
+	router.Use(GzipHandler)
+	router.Use(signResponse(config.Key))
+	writeAccess := trustedSubnet(config.TrustedSubnet)
+	readAccess := trustedSubnet(config.ReadSubnet)
+	keys := newIdempotencyKeys(time.Duration(config.IdempotencyTTL)*time.Second, storage)
+	// Agent is registered only by write request from trusted subnet with
+	// right sign.
+	identify := agentIdentity(agents)
+	write := func(handler http.Handler) http.HandlerFunc {
+		return middlewareLogger(writeAccess(verifySign(config.Key, identify(idempotent(keys, handler)))), sugar)
+	}
+	router.Post("/update", write(SaveMetricHandle(storage)))
+	router.Post("/update/", write(SaveMetricHandle(storage)))
+	router.Post("/updates", write(SaveBatchMetricHandle(storage)))
+	router.Post("/updates/", write(SaveBatchMetricHandle(storage)))
+	router.Post("/update/{mType}/{metric}/{value}", write(SaveMetricHandleOld(storage)))
+	// Deletes and resets repeat safely, so they don't need idempotency keys.
+	admin := func(handler http.Handler) http.HandlerFunc {
+		return middlewareLogger(adminAuth(config.AdminToken)(handler), sugar)
+	}
+	router.Delete("/value/{mType}/{metric}", admin(DeleteMetricHandle(storage)))
+	router.Delete("/value", admin(DeleteMetricsHandle(storage)))
+	router.Delete("/value/", admin(DeleteMetricsHandle(storage)))
+	router.Post("/reset/counter/{metric}", admin(ResetCounterHandle(storage)))
+	router.Get("/value/{mType}/{metric}",
+		middlewareLogger(readAccess(GetMetricHandle(storage)), sugar))
+	router.Post("/value",
+		middlewareLogger(readAccess(GetJSONMetricHandle(storage)), sugar))
+	router.Post("/value/",
+		middlewareLogger(readAccess(GetJSONMetricHandle(storage)), sugar))
+	router.Post("/value/aggregate",
+		middlewareLogger(readAccess(AggregateHandle(storage)), sugar))
+	router.Get("/ping", middlewareLogger(pingDBHandle(storage), sugar))
+	router.Get("/", middlewareLogger(readAccess(ListMetricHandle(storage)), sugar))
+	router.Get("/metrics", middlewareLogger(readAccess(PrometheusHandle(storage)), sugar))
+	router.Get("/api/v1/query_range", middlewareLogger(readAccess(QueryRangeHandle(storage)), sugar))
+	router.Get("/api/v1/agents", middlewareLogger(readAccess(
+		ListAgentsHandle(agents, time.Duration(config.AgentStaleTimeout)*time.Second)), sugar))
+	return router
+}
+
 func Routing() (err error) {
 	// Initialize logger
 	logger, err := zap.NewDevelopment()
@@ -57,62 +110,95 @@ func Routing() (err error) {
 		}
 	}()
 	sugar := *logger.Sugar()
-	storage := types.GetMemStorage()
 
 	config, err := GetConfig()
 	if err != nil {
 		return fmt.Errorf("error in GetConfig: %w", err)
 	}
 
-	if config.Restore {
-		err = restoreMetricStore(config.FileStoragePath, storage)
-		var storageFileNotFound *fs.PathError
-		if errors.As(err, &storageFileNotFound) {
+	if config.MigrateOnly || config.MigrateDown > 0 {
+		return runMigrations(config)
+	}
 
-		} else if err != nil {
-			return fmt.Errorf("error with restore MemStorage from file: %w", err)
+	var privateKey *rsa.PrivateKey
+	if config.CryptoKey != "" {
+		privateKey, err = types.LoadPrivateKey(config.CryptoKey)
+		if err != nil {
+			return fmt.Errorf("error in load crypto key: %w", err)
 		}
 	}
 
-	syncInfo, err := GetSyncInfo(*config)
+	tlsConfig, err := newTLSConfig(config)
 	if err != nil {
-		return fmt.Errorf("error in GetSyncInfo: %w", err)
+		return err
 	}
 
-	if syncInfo.DB != nil {
-		defer func() {
-			err = syncInfo.DB.Close()
-		}()
-	} else if syncInfo.DB == nil && !syncInfo.SyncFileRecord {
+	storage, err := NewStorage(config)
+	if err != nil {
+		return fmt.Errorf("error in NewStorage: %w", err)
+	}
+	defer func() {
+		closeErr := storage.Close()
+		if closeErr != nil && err == nil {
+			err = fmt.Errorf("error in close storage: %w", closeErr)
+		}
+	}()
+
+	agents := types.NewAgentRegistry()
+	router := newRouter(config, storage, agents, sugar)
+	srv := &http.Server{
+		Addr:              config.HostPort.String(),
+		Handler:           decryptHandler(privateKey)(router),
+		ReadHeaderTimeout: readHeaderTimeout,
+		TLSConfig:         tlsConfig,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	defer stop()
+
+	serveErr := make(chan error, 2)
+	var grpcServer *grpc.Server
+	if config.GRPCAddress != "" {
+		listener, err := net.Listen("tcp", config.GRPCAddress)
+		if err != nil {
+			return fmt.Errorf("error in listen gRPC address: %w", err)
+		}
+		grpcServer = newGRPCServer(config, storage, agents, tlsConfig)
+		defer grpcServer.Stop()
 		go func() {
-			err = fileDump(config.FileStoragePath, time.Duration(config.StoreInterval)*time.Second, storage)
+			serveErr <- grpcServer.Serve(listener)
 		}()
 	}
+	go func() {
+		if tlsConfig != nil {
+			// Certificates are already loaded into TLSConfig.
+			serveErr <- srv.ListenAndServeTLS("", "")
+			return
+		}
+		serveErr <- srv.ListenAndServe()
+	}()
 
-	router := chi.NewRouter()
-	router.Use(GzipHandler)
-	router.Post("/update",
-		middlewareLogger(SaveMetricHandle(storage, syncInfo), sugar))
-	router.Post("/update/",
-		middlewareLogger(SaveMetricHandle(storage, syncInfo), sugar))
-	router.Post("/updates",
-		middlewareLogger(SaveBatchMetricHandle(storage, syncInfo), sugar))
-	router.Post("/updates/",
-		middlewareLogger(SaveBatchMetricHandle(storage, syncInfo), sugar))
-	router.Post("/update/{mType}/{metric}/{value}",
-		middlewareLogger(SaveMetricHandleOld(storage, syncInfo), sugar))
-	router.Get("/value/{mType}/{metric}",
-		middlewareLogger(GetMetricHandle(storage), sugar))
-	router.Post("/value",
-		middlewareLogger(GetJSONMetricHandle(storage), sugar))
-	router.Post("/value/",
-		middlewareLogger(GetJSONMetricHandle(storage), sugar))
-	router.Get("/ping", middlewareLogger(pingDBHandle(config.DBAddress), sugar))
-	router.Get("/", middlewareLogger(ListMetricHandle(storage), sugar))
+	select {
+	case err = <-serveErr:
+		return fmt.Errorf("error with launch server: %w", err)
+	case <-ctx.Done():
+		sugar.Infoln("shutdown signal received, draining requests")
+	}
 
-	err = http.ListenAndServe(config.HostPort.String(), router)
+	// Storage is closed by deferred call only after in-flight requests are
+	// done, so the final flush contains all of them.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if grpcServer != nil {
+		go func() {
+			<-shutdownCtx.Done()
+			grpcServer.Stop()
+		}()
+		grpcServer.GracefulStop()
+	}
+	err = srv.Shutdown(shutdownCtx)
 	if err != nil {
-		return fmt.Errorf("error with launch http server: %w", err)
+		return fmt.Errorf("error in shutdown http server: %w", err)
 	}
 	return nil
 }
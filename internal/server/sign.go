@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/xChygyNx/metrical/internal/server/types"
+)
+
+const badSignMsg = "missing or invalid " + types.HashHeader + " header"
+
+// signingResponseWriter holds response until handler is done, so its body can
+// be signed in header.
+type signingResponseWriter struct {
+	http.ResponseWriter
+	body   bytes.Buffer
+	status int
+}
+
+func (sw *signingResponseWriter) WriteHeader(statusCode int) {
+	if sw.status == 0 {
+		sw.status = statusCode
+	}
+}
+
+func (sw *signingResponseWriter) Write(b []byte) (int, error) {
+	return sw.body.Write(b)
+}
+
+func (sw *signingResponseWriter) flush(key string) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	if sw.body.Len() > 0 {
+		sw.Header().Set(types.HashHeader, types.Sign(sw.body.Bytes(), key))
+	}
+	sw.ResponseWriter.WriteHeader(sw.status)
+	_, err := sw.ResponseWriter.Write(sw.body.Bytes())
+	if err != nil {
+		log.Printf("error in write signed response: %v\n", err)
+	}
+}
+
+// signResponse signs bodies of all responses when key is set. Empty bodies
+// aren't signed, because their signature is the same for all requests.
+func signResponse(key string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if key == "" {
+			return next
+		}
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			sw := &signingResponseWriter{ResponseWriter: res}
+			next.ServeHTTP(sw, req)
+			sw.flush(key)
+		})
+	}
+}
+
+// verifySign rejects requests whose body is not signed by key. It must run
+// after GzipHandler, because signature covers uncompressed body. Signature of
+// request without body covers its method, path and query.
+func verifySign(key string, next http.Handler) http.Handler {
+	if key == "" {
+		return next
+	}
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			log.Printf("error in read request body: %v\n", err)
+			writeError(res, internalServerErrorMsg, http.StatusInternalServerError)
+			return
+		}
+		signed := body
+		if len(body) == 0 {
+			signed = types.RequestSignData(req.Method, req.URL.RequestURI())
+		}
+		if !types.CheckSign(signed, key, req.Header.Get(types.HashHeader)) {
+			writeError(res, badSignMsg, http.StatusBadRequest)
+			return
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(res, req)
+	})
+}
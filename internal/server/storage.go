@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/xChygyNx/metrical/internal/server/types"
+)
+
+var (
+	ErrMetricNotFound  = errors.New("metric not found")
+	ErrUnknownType     = errors.New("unknown metric type, must be gauge, counter, histogram or summary")
+	ErrDBNotConfigured = errors.New("data base is not configured")
+)
+
+// Storage hides from handlers where and how metrics are persisted. Metric is
+// identified by type, name and labels; nil labels mean label-less metric.
+type Storage interface {
+	UpdateGauge(ctx context.Context, name string, labels map[string]string, value float64) (float64, error)
+	AddCounter(ctx context.Context, name string, labels map[string]string, delta int64) (int64, error)
+	// AddHistogram adds observations to histogram, it fails with
+	// types.ErrBoundsMismatch if bounds differ from saved ones.
+	AddHistogram(ctx context.Context, name string, labels map[string]string,
+		histogram types.Histogram) (types.Histogram, error)
+	UpdateSummary(ctx context.Context, name string, labels map[string]string,
+		summary types.Summary) (types.Summary, error)
+	Get(ctx context.Context, mType, name string, labels map[string]string) (types.Metrics, error)
+	// List returns metrics which have all labels of filter.
+	List(ctx context.Context, filter map[string]string) ([]types.Metrics, error)
+	UpdateBatch(ctx context.Context, metrics []types.Metrics) ([]types.Metrics, error)
+	QueryRange(ctx context.Context, mType, name string, labels map[string]string,
+		from, to time.Time) ([]types.Sample, error)
+	// Delete removes metric with its history, it fails with ErrMetricNotFound
+	// if there is no such metric.
+	Delete(ctx context.Context, mType, name string, labels map[string]string) error
+	// DeleteMatching removes metrics whose names match glob pattern and which
+	// have all labels of filter. Empty mType matches metrics of any type.
+	DeleteMatching(ctx context.Context, mType, pattern string, filter map[string]string) ([]types.Metrics, error)
+	// ResetCounter sets counter to zero, it fails with ErrMetricNotFound if
+	// there is no such counter.
+	ResetCounter(ctx context.Context, name string, labels map[string]string) error
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// NewStorage selects storage backend by config: PostgreSQL if DBAddress is set,
+// file if FileStoragePath is set, memory otherwise.
+func NewStorage(conf *Config) (Storage, error) {
+	if conf.DBAddress != "" {
+		db, err := createMetricDB(conf.DBAddress)
+		if err != nil {
+			return nil, fmt.Errorf("error in create Metric Data Base: %w", err)
+		}
+		return newDBStorage(db, conf.HistorySize), nil
+	}
+
+	mem := newMemStorage(conf.HistorySize)
+	if conf.Restore && conf.FileStoragePath != "" {
+		err := restoreMetricStore(conf.FileStoragePath, mem.storage)
+		var storageFileNotFound *fs.PathError
+		if err != nil && !errors.As(err, &storageFileNotFound) {
+			return nil, fmt.Errorf("error with restore MemStorage from file: %w", err)
+		}
+	}
+
+	if conf.FileStoragePath != "" {
+		return newFileStorage(mem, conf.FileStoragePath, time.Duration(conf.StoreInterval)*time.Second), nil
+	}
+	return mem, nil
+}
+
+// matchMetrics returns metrics of type mType, or of any type if it is empty,
+// whose names match glob pattern, sorted by type and name. Values of metrics
+// are left out.
+func matchMetrics(metrics []types.Metrics, mType, pattern string) ([]types.Metrics, error) {
+	matched := make([]types.Metrics, 0)
+	for _, metric := range metrics {
+		if mType != "" && metric.MType != mType {
+			continue
+		}
+		ok, err := path.Match(pattern, metric.ID)
+		if err != nil {
+			return nil, fmt.Errorf("error in match pattern %s: %w", pattern, err)
+		}
+		if ok {
+			matched = append(matched, types.Metrics{ID: metric.ID, MType: metric.MType, Labels: metric.Labels})
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if matched[i].MType != matched[j].MType {
+			return matched[i].MType < matched[j].MType
+		}
+		return types.SeriesKey(matched[i].ID, matched[i].Labels) < types.SeriesKey(matched[j].ID, matched[j].Labels)
+	})
+	return matched, nil
+}
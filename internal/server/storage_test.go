@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStorage(t *testing.T) {
+	dir := t.TempDir()
+	malformedPath := filepath.Join(dir, "malformed.json")
+
+	tests := []struct {
+		name    string
+		config  Config
+		want    Storage
+		wantErr bool
+	}{
+		{
+			name: "Memory by default",
+			want: &memStorage{},
+		},
+		{
+			name:   "File if path is set",
+			config: Config{FileStoragePath: filepath.Join(dir, "metrics.json")},
+			want:   &fileStorage{},
+		},
+		{
+			name:   "Missing file is not restored",
+			config: Config{FileStoragePath: filepath.Join(dir, "missing.json"), Restore: true},
+			want:   &fileStorage{},
+		},
+		{
+			name:    "Malformed file is restored",
+			config:  Config{FileStoragePath: malformedPath, Restore: true},
+			wantErr: true,
+		},
+		{
+			name:   "Malformed file without restore",
+			config: Config{FileStoragePath: malformedPath},
+			want:   &fileStorage{},
+		},
+		{
+			name: "Data base takes precedence over file",
+			config: Config{
+				DBAddress:       "postgres://metrics@127.0.0.1:1/metrics?connect_timeout=1",
+				FileStoragePath: filepath.Join(dir, "metrics.json"),
+			},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.NoError(t, os.WriteFile(malformedPath, []byte("not json"), 0o600))
+			test.config.HistorySize = defaultHistorySize
+			storage, err := NewStorage(&test.config)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.IsType(t, test.want, storage)
+			require.NoError(t, storage.Close())
+		})
+	}
+}
+
+func TestFileStorageRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	config := &Config{
+		FileStoragePath: filepath.Join(t.TempDir(), "metrics.json"),
+		HistorySize:     defaultHistorySize,
+		Restore:         true,
+	}
+	storage, err := NewStorage(config)
+	require.NoError(t, err)
+	_, err = storage.UpdateGauge(ctx, "Alloc", nil, 1.5)
+	require.NoError(t, err)
+	_, err = storage.AddCounter(ctx, "PollCount", nil, 3)
+	require.NoError(t, err)
+	_, err = storage.AddCounter(ctx, "PollCount", nil, 4)
+	require.NoError(t, err)
+	require.NoError(t, storage.Close())
+
+	restored, err := NewStorage(config)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, restored.Close())
+	}()
+	metric, err := restored.Get(ctx, GAUGE, "Alloc", nil)
+	require.NoError(t, err)
+	assert.InDelta(t, 1.5, *metric.Value, 0)
+	metric, err = restored.Get(ctx, COUNTER, "PollCount", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), *metric.Delta)
+	_, err = restored.Get(ctx, GAUGE, "HeapSys", nil)
+	assert.ErrorIs(t, err, ErrMetricNotFound)
+}
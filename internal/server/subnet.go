@@ -0,0 +1,70 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+)
+
+const (
+	realIPHeader   = "X-Real-IP"
+	untrustedIPMsg = "address is not in trusted subnet"
+)
+
+// Subnet is a CIDR flag value. Zero Subnet trusts any address.
+type Subnet struct {
+	*net.IPNet
+}
+
+func (sn *Subnet) String() string {
+	if sn.IPNet == nil {
+		return ""
+	}
+	return sn.IPNet.String()
+}
+
+func (sn *Subnet) Set(value string) error {
+	if value == "" {
+		sn.IPNet = nil
+		return nil
+	}
+	_, ipNet, err := net.ParseCIDR(value)
+	if err != nil {
+		return fmt.Errorf("must be CIDR like 192.168.0.0/24, got %s: %w", value, err)
+	}
+	sn.IPNet = ipNet
+	return nil
+}
+
+// clientIP returns address reported by client in X-Real-IP, or remote
+// address of connection when client did not report it.
+func clientIP(realIP, remoteAddr string) net.IP {
+	if realIP != "" {
+		return net.ParseIP(realIP)
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return net.ParseIP(remoteAddr)
+	}
+	return net.ParseIP(host)
+}
+
+func (sn *Subnet) allows(ip net.IP) bool {
+	return sn.IPNet == nil || ip != nil && sn.Contains(ip)
+}
+
+// trustedSubnet forbids requests from addresses outside of subnet.
+func trustedSubnet(subnet Subnet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if subnet.IPNet == nil {
+			return next
+		}
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			if !subnet.allows(clientIP(req.Header.Get(realIPHeader), req.RemoteAddr)) {
+				writeError(res, untrustedIPMsg, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(res, req)
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var errBadCABundle = errors.New("CA bundle has no PEM encoded certificates")
+
+// newTLSConfig returns TLS config of server, or nil when server serves plain
+// HTTP. If client CA bundle is set, clients must present certificate
+// signed by one of its authorities.
+func newTLSConfig(conf *Config) (*tls.Config, error) {
+	if conf.TLSCert == "" && conf.TLSKey == "" {
+		if conf.TLSClientCA != "" {
+			return nil, errors.New("client certificates require TLS certificate and key of server")
+		}
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(conf.TLSCert, conf.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("error in load TLS certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if conf.TLSClientCA != "" {
+		pool, err := loadCertPool(conf.TLSClientCA)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error in read CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errBadCABundle
+	}
+	return pool, nil
+}
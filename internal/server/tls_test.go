@@ -0,0 +1,133 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/xChygyNx/metrical/internal/server/types"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// issueCert creates certificate signed by parent, or self-signed CA if
+// parent is nil, and writes it with its key to dir.
+func issueCert(t *testing.T, dir, name string, parent *testCert, client bool) testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if client {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	signer := testCert{cert: template, key: key}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.ExtKeyUsage = nil
+	} else {
+		signer = *parent
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer.cert, &key.PublicKey, signer.key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".crt"),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".key"),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return testCert{cert: cert, key: key}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := issueCert(t, dir, "ca", nil, false)
+	issueCert(t, dir, "server", &ca, false)
+	issueCert(t, dir, "agent-1", &ca, true)
+
+	tlsConfig, err := newTLSConfig(&Config{
+		TLSCert:     filepath.Join(dir, "server.crt"),
+		TLSKey:      filepath.Join(dir, "server.key"),
+		TLSClientCA: filepath.Join(dir, "ca.crt"),
+	})
+	require.NoError(t, err)
+
+	agents := types.NewAgentRegistry()
+	config := &Config{AgentStaleTimeout: defaultAgentStaleTimeout}
+	srv := httptest.NewUnstartedServer(
+		newRouter(config, newMemStorage(defaultHistorySize), agents, *zap.NewNop().Sugar()))
+	srv.TLS = tlsConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	newClient := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: certs,
+			MinVersion:   tls.VersionTLS12,
+		}}}
+	}
+
+	resp, err := newClient().Get(srv.URL + "/")
+	if err == nil {
+		_ = resp.Body.Close()
+	}
+	assert.Error(t, err, "client without certificate must be rejected")
+
+	clientCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "agent-1.crt"), filepath.Join(dir, "agent-1.key"))
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/update",
+		strings.NewReader(`{"id":"Alloc","type":"gauge","value":1}`))
+	require.NoError(t, err)
+	req.Header.Set(types.AgentIDHeader, "spoofed")
+	resp, err = newClient(clientCert).Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	seen := agents.List(time.Now(), time.Minute)
+	require.Len(t, seen, 1)
+	assert.Equal(t, "agent-1", seen[0].ID)
+}
+
+func TestTLSConfigRequiresServerCert(t *testing.T) {
+	tlsConfig, err := newTLSConfig(&Config{})
+	assert.NoError(t, err)
+	assert.Nil(t, tlsConfig)
+
+	_, err = newTLSConfig(&Config{TLSClientCA: "ca.crt"})
+	assert.Error(t, err)
+}
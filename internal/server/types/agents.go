@@ -0,0 +1,75 @@
+package types
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// AgentIDHeader carries stable ID of agent in its requests.
+	AgentIDHeader = "X-Agent-ID"
+	// MaxAgents is the number of agents registry remembers.
+	MaxAgents = 10000
+)
+
+// AgentInfo describes agent which reported metrics to server.
+type AgentInfo struct {
+	LastSeen   time.Time `json:"last_seen"`
+	ID         string    `json:"id"`
+	RemoteAddr string    `json:"remote_addr"`
+	Stale      bool      `json:"stale"`
+}
+
+// AgentRegistry remembers when every agent reported last time. When there
+// are MaxAgents agents, the one which reported the earliest is forgotten.
+type AgentRegistry struct {
+	agents map[string]AgentInfo
+	mu     sync.RWMutex
+}
+
+func NewAgentRegistry() *AgentRegistry {
+	return &AgentRegistry{
+		agents: make(map[string]AgentInfo),
+	}
+}
+
+// Seen records report of agent id at moment now.
+func (ar *AgentRegistry) Seen(id, remoteAddr string, now time.Time) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	if _, ok := ar.agents[id]; !ok && len(ar.agents) >= MaxAgents {
+		ar.forgetOldest()
+	}
+	ar.agents[id] = AgentInfo{
+		ID:         id,
+		RemoteAddr: remoteAddr,
+		LastSeen:   now,
+	}
+}
+
+func (ar *AgentRegistry) forgetOldest() {
+	var oldest AgentInfo
+	for _, agent := range ar.agents {
+		if oldest.ID == "" || agent.LastSeen.Before(oldest.LastSeen) {
+			oldest = agent
+		}
+	}
+	delete(ar.agents, oldest.ID)
+}
+
+// List returns agents sorted by id. Agent is stale if it didn't report for
+// longer than staleAfter before now.
+func (ar *AgentRegistry) List(now time.Time, staleAfter time.Duration) []AgentInfo {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+	agents := make([]AgentInfo, 0, len(ar.agents))
+	for _, agent := range ar.agents {
+		agent.Stale = now.Sub(agent.LastSeen) > staleAfter
+		agents = append(agents, agent)
+	}
+	sort.Slice(agents, func(i, j int) bool {
+		return agents[i].ID < agents[j].ID
+	})
+	return agents
+}
@@ -0,0 +1,166 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	AggMin      = "min"
+	AggMax      = "max"
+	AggAvg      = "avg"
+	AggSum      = "sum"
+	AggCount    = "count"
+	AggLast     = "last"
+	AggRate     = "rate"
+	AggQuantile = "quantile"
+)
+
+var (
+	ErrUnknownAggregation = errors.New("unknown aggregation function")
+	ErrBadQuantile        = errors.New("quantile must be in [0, 1]")
+)
+
+// AggregateRequest is a body of aggregation query. If From is not set, the
+// window ends at To (now by default) and lasts Window (1h by default).
+type AggregateRequest struct {
+	From     *time.Time        `json:"from,omitempty"`
+	To       *time.Time        `json:"to,omitempty"`
+	Quantile *float64          `json:"quantile,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	ID       string            `json:"id"`
+	MType    string            `json:"type"`
+	Func     string            `json:"func"`
+	Window   string            `json:"window,omitempty"`
+}
+
+// AggregateResult is a response of aggregation query. Value is null when
+// the window has not enough samples for the function.
+type AggregateResult struct {
+	From     time.Time         `json:"from"`
+	To       time.Time         `json:"to"`
+	Value    *float64          `json:"value"`
+	Quantile *float64          `json:"quantile,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	ID       string            `json:"id"`
+	MType    string            `json:"type"`
+	Func     string            `json:"func"`
+	Count    int               `json:"count"`
+}
+
+// quantileAliases are shortcuts for popular percentiles.
+var quantileAliases = map[string]float64{
+	"p50": 0.5,
+	"p90": 0.9,
+	"p95": 0.95,
+	"p99": 0.99,
+}
+
+// ResolveAggregation returns canonical name of function and its quantile,
+// if function is a quantile.
+func ResolveAggregation(fn string, quantile *float64) (string, *float64, error) {
+	if q, ok := quantileAliases[fn]; ok {
+		return AggQuantile, &q, nil
+	}
+	switch fn {
+	case AggMin, AggMax, AggAvg, AggSum, AggCount, AggLast, AggRate:
+		return fn, nil, nil
+	case AggQuantile:
+		if quantile == nil || *quantile < 0 || *quantile > 1 {
+			return fn, nil, ErrBadQuantile
+		}
+		return fn, quantile, nil
+	default:
+		return fn, nil, fmt.Errorf("%w %s", ErrUnknownAggregation, fn)
+	}
+}
+
+// Aggregate applies function fn to chronologically ordered samples. It returns
+// false if samples are not enough to compute the function.
+func Aggregate(samples []Sample, fn string, quantile float64) (float64, bool) {
+	if fn == AggCount {
+		return float64(len(samples)), true
+	}
+	if len(samples) == 0 {
+		return 0, false
+	}
+
+	switch fn {
+	case AggMin:
+		result := samples[0].Value
+		for _, sample := range samples[1:] {
+			result = math.Min(result, sample.Value)
+		}
+		return result, true
+	case AggMax:
+		result := samples[0].Value
+		for _, sample := range samples[1:] {
+			result = math.Max(result, sample.Value)
+		}
+		return result, true
+	case AggSum:
+		return sum(samples), true
+	case AggAvg:
+		return sum(samples) / float64(len(samples)), true
+	case AggLast:
+		return samples[len(samples)-1].Value, true
+	case AggRate:
+		return rate(samples)
+	case AggQuantile:
+		return quantileOf(samples, quantile), true
+	default:
+		return 0, false
+	}
+}
+
+func sum(samples []Sample) float64 {
+	var result float64
+	for _, sample := range samples {
+		result += sample.Value
+	}
+	return result
+}
+
+// rate is per-second increase of counter. A decrease of value means the
+// counter was reset, then the whole new value counts as increase.
+func rate(samples []Sample) (float64, bool) {
+	if len(samples) < 2 {
+		return 0, false
+	}
+	first, last := samples[0], samples[len(samples)-1]
+	elapsed := last.Timestamp.Sub(first.Timestamp).Seconds()
+	if elapsed <= 0 {
+		return 0, false
+	}
+
+	var increase float64
+	for i := 1; i < len(samples); i++ {
+		diff := samples[i].Value - samples[i-1].Value
+		if diff < 0 {
+			diff = samples[i].Value
+		}
+		increase += diff
+	}
+	return increase / elapsed, true
+}
+
+// quantileOf interpolates linearly between closest ranks.
+func quantileOf(samples []Sample, quantile float64) float64 {
+	values := make([]float64, 0, len(samples))
+	for _, sample := range samples {
+		values = append(values, sample.Value)
+	}
+	sort.Float64s(values)
+
+	rank := quantile * float64(len(values)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	if lower == upper {
+		return values[lower]
+	}
+	weight := rank - float64(lower)
+	return values[lower]*(1-weight) + values[upper]*weight
+}
@@ -0,0 +1,127 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+var ErrBoundsMismatch = errors.New("histogram bounds differ from saved ones")
+
+// Histogram is distribution of observations. Counts[i] observations are
+// greater than Bounds[i-1] and not greater than Bounds[i]; the last count is
+// for observations greater than all bounds. Histogram sent for update holds
+// observations since previous update, so its counts and sum are added to
+// saved ones like counter delta.
+type Histogram struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []int64   `json:"counts"`
+	Sum    float64   `json:"sum"`
+}
+
+// Quantile is value below which Quantile part of observations falls.
+type Quantile struct {
+	Quantile float64 `json:"quantile"`
+	Value    float64 `json:"value"`
+}
+
+// Summary is distribution of observations precomputed by client. Quantiles
+// can't be merged, so new summary replaces saved one like gauge value.
+type Summary struct {
+	Quantiles []Quantile `json:"quantiles"`
+	Sum       float64    `json:"sum"`
+	Count     int64      `json:"count"`
+}
+
+// Count returns number of observations in histogram.
+func (h *Histogram) Count() int64 {
+	var count int64
+	for _, c := range h.Counts {
+		count += c
+	}
+	return count
+}
+
+// Clone returns copy of histogram which doesn't share slices with it.
+func (h *Histogram) Clone() Histogram {
+	return Histogram{
+		Bounds: append([]float64(nil), h.Bounds...),
+		Counts: append([]int64(nil), h.Counts...),
+		Sum:    h.Sum,
+	}
+}
+
+// Merge adds observations of other histogram with the same bounds.
+func (h *Histogram) Merge(other Histogram) error {
+	if len(h.Bounds) != len(other.Bounds) {
+		return ErrBoundsMismatch
+	}
+	for i, bound := range h.Bounds {
+		if bound != other.Bounds[i] {
+			return ErrBoundsMismatch
+		}
+	}
+	for i, count := range other.Counts {
+		h.Counts[i] += count
+	}
+	h.Sum += other.Sum
+	return nil
+}
+
+// Validate checks that bounds are finite and increase, and there is count
+// for every bucket including the last one above all bounds.
+func (h *Histogram) Validate() error {
+	for i, bound := range h.Bounds {
+		if math.IsNaN(bound) || math.IsInf(bound, 0) {
+			return &ValidationError{Field: "histogram.bounds", Message: "must be finite numbers"}
+		}
+		if i > 0 && bound <= h.Bounds[i-1] {
+			return &ValidationError{Field: "histogram.bounds", Message: "must increase"}
+		}
+	}
+	if len(h.Counts) != len(h.Bounds)+1 {
+		errorMsg := fmt.Sprintf("must have %d items, one more than bounds", len(h.Bounds)+1)
+		return &ValidationError{Field: "histogram.counts", Message: errorMsg}
+	}
+	for _, count := range h.Counts {
+		if count < 0 {
+			return &ValidationError{Field: "histogram.counts", Message: "must not be negative"}
+		}
+	}
+	if math.IsNaN(h.Sum) || math.IsInf(h.Sum, 0) {
+		return &ValidationError{Field: "histogram.sum", Message: "must be finite number"}
+	}
+	return nil
+}
+
+// Clone returns copy of summary which doesn't share quantiles with it.
+func (s *Summary) Clone() Summary {
+	return Summary{
+		Quantiles: append([]Quantile(nil), s.Quantiles...),
+		Sum:       s.Sum,
+		Count:     s.Count,
+	}
+}
+
+// Validate checks that quantiles are in [0, 1] and increase, and values are
+// finite.
+func (s *Summary) Validate() error {
+	for i, quantile := range s.Quantiles {
+		if quantile.Quantile < 0 || quantile.Quantile > 1 {
+			return &ValidationError{Field: "summary.quantiles", Message: "quantile must be in [0, 1]"}
+		}
+		if i > 0 && quantile.Quantile <= s.Quantiles[i-1].Quantile {
+			return &ValidationError{Field: "summary.quantiles", Message: "quantiles must increase"}
+		}
+		if math.IsNaN(quantile.Value) || math.IsInf(quantile.Value, 0) {
+			return &ValidationError{Field: "summary.quantiles", Message: "values must be finite numbers"}
+		}
+	}
+	if s.Count < 0 {
+		return &ValidationError{Field: "summary.count", Message: "must not be negative"}
+	}
+	if math.IsNaN(s.Sum) || math.IsInf(s.Sum, 0) {
+		return &ValidationError{Field: "summary.sum", Message: "must be finite number"}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package types
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// EncryptionHeader names scheme of encrypted request body.
+const EncryptionHeader = "X-Encryption"
+
+const (
+	// EncryptionRSA is a body encrypted by RSA-OAEP with SHA-256.
+	EncryptionRSA = "rsa-oaep"
+	// EncryptionHybrid is a body encrypted by AES-256-GCM with random key,
+	// laid out as RSA-OAEP encrypted key, nonce and sealed data.
+	EncryptionHybrid = "rsa-oaep+aes-gcm"
+	aesKeySize       = 32
+)
+
+var (
+	ErrBadKeyFile        = errors.New("key file must contain PEM encoded RSA key")
+	ErrUnknownEncryption = errors.New("unknown encryption scheme")
+	ErrBadCiphertext     = errors.New("encrypted body is malformed")
+)
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error in read key file: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrBadKeyFile
+	}
+	return block, nil
+}
+
+// LoadPublicKey reads RSA public key in PKIX or PKCS #1 PEM file.
+func LoadPublicKey(path string) (*rsa.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type == "RSA PUBLIC KEY" {
+		key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error in parse public key: %w", err)
+		}
+		return key, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error in parse public key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, ErrBadKeyFile
+	}
+	return rsaKey, nil
+}
+
+// LoadPrivateKey reads RSA private key in PKCS #1 or PKCS #8 PEM file.
+func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error in parse private key: %w", err)
+		}
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error in parse private key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ErrBadKeyFile
+	}
+	return rsaKey, nil
+}
+
+// Encrypt encrypts data by public key. Data which does not fit in one RSA
+// block is encrypted by hybrid scheme. It returns ciphertext and its scheme.
+func Encrypt(key *rsa.PublicKey, data []byte) ([]byte, string, error) {
+	hash := sha256.New()
+	if len(data) <= key.Size()-2*hash.Size()-2 {
+		ciphertext, err := rsa.EncryptOAEP(hash, rand.Reader, key, data, nil)
+		if err != nil {
+			return nil, "", fmt.Errorf("error in RSA encrypt: %w", err)
+		}
+		return ciphertext, EncryptionRSA, nil
+	}
+
+	aesKey := make([]byte, aesKeySize)
+	_, err := rand.Read(aesKey)
+	if err != nil {
+		return nil, "", fmt.Errorf("error in generate AES key: %w", err)
+	}
+	encryptedKey, err := rsa.EncryptOAEP(hash, rand.Reader, key, aesKey, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("error in RSA encrypt of AES key: %w", err)
+	}
+	gcm, err := newGCM(aesKey)
+	if err != nil {
+		return nil, "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, "", fmt.Errorf("error in generate nonce: %w", err)
+	}
+
+	result := make([]byte, 0, len(encryptedKey)+len(nonce)+len(data)+gcm.Overhead())
+	result = append(result, encryptedKey...)
+	result = append(result, nonce...)
+	return gcm.Seal(result, nonce, data, nil), EncryptionHybrid, nil
+}
+
+// Decrypt reverses Encrypt.
+func Decrypt(key *rsa.PrivateKey, scheme string, data []byte) ([]byte, error) {
+	hash := sha256.New()
+	switch scheme {
+	case EncryptionRSA:
+		plain, err := rsa.DecryptOAEP(hash, rand.Reader, key, data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error in RSA decrypt: %w", err)
+		}
+		return plain, nil
+	case EncryptionHybrid:
+		if len(data) < key.Size() {
+			return nil, ErrBadCiphertext
+		}
+		aesKey, err := rsa.DecryptOAEP(hash, rand.Reader, key, data[:key.Size()], nil)
+		if err != nil {
+			return nil, fmt.Errorf("error in RSA decrypt of AES key: %w", err)
+		}
+		gcm, err := newGCM(aesKey)
+		if err != nil {
+			return nil, err
+		}
+		data = data[key.Size():]
+		if len(data) < gcm.NonceSize() {
+			return nil, ErrBadCiphertext
+		}
+		plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+		if err != nil {
+			return nil, fmt.Errorf("error in AES decrypt: %w", err)
+		}
+		return plain, nil
+	default:
+		return nil, fmt.Errorf("%w %s", ErrUnknownEncryption, scheme)
+	}
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error in create AES cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("error in create GCM: %w", err)
+	}
+	return gcm, nil
+}
@@ -0,0 +1,128 @@
+package types
+
+import (
+	"sync"
+	"time"
+)
+
+// Sample is a value of metric at the moment of its update. Counters are
+// sampled by their running total.
+type Sample struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+}
+
+// RangeResult is a response of range query.
+type RangeResult struct {
+	Labels map[string]string `json:"labels,omitempty"`
+	ID     string            `json:"id"`
+	MType  string            `json:"type"`
+	Points []Sample          `json:"points"`
+}
+
+// sampleRing keeps last len(samples) samples of one metric.
+type sampleRing struct {
+	samples []Sample
+	next    int
+	full    bool
+}
+
+func (sr *sampleRing) add(sample Sample) {
+	sr.samples[sr.next] = sample
+	sr.next++
+	if sr.next == len(sr.samples) {
+		sr.next = 0
+		sr.full = true
+	}
+}
+
+// between returns samples from [from, to] in chronological order.
+func (sr *sampleRing) between(from, to time.Time) []Sample {
+	ordered := sr.samples[:sr.next]
+	if sr.full {
+		ordered = append(append(make([]Sample, 0, len(sr.samples)), sr.samples[sr.next:]...), sr.samples[:sr.next]...)
+	}
+	result := make([]Sample, 0)
+	for _, sample := range ordered {
+		if sample.Timestamp.Before(from) || sample.Timestamp.After(to) {
+			continue
+		}
+		result = append(result, sample)
+	}
+	return result
+}
+
+// History is a bounded in-memory store of samples per metric.
+type History struct {
+	series   map[string]*sampleRing
+	capacity int
+	mu       sync.Mutex
+}
+
+func NewHistory(capacity int) *History {
+	return &History{
+		series:   make(map[string]*sampleRing),
+		capacity: capacity,
+	}
+}
+
+func seriesKey(mType, mName string) string {
+	return mType + "/" + mName
+}
+
+// Add records sample of metric, evicting the oldest one when buffer is full.
+func (h *History) Add(mType, mName string, sample Sample) {
+	if h.capacity <= 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := seriesKey(mType, mName)
+	ring, ok := h.series[key]
+	if !ok {
+		ring = &sampleRing{samples: make([]Sample, h.capacity)}
+		h.series[key] = ring
+	}
+	ring.add(sample)
+}
+
+// Delete forgets all samples of metric.
+func (h *History) Delete(mType, mName string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.series, seriesKey(mType, mName))
+}
+
+// Range returns samples of metric recorded in [from, to].
+func (h *History) Range(mType, mName string, from, to time.Time) []Sample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ring, ok := h.series[seriesKey(mType, mName)]
+	if !ok {
+		return []Sample{}
+	}
+	return ring.between(from, to)
+}
+
+// Downsample turns chronologically ordered samples into points at from,
+// from+step, ... up to to. Each point takes the last sample of the step
+// ending at it; steps without samples are skipped.
+func Downsample(samples []Sample, from, to time.Time, step time.Duration) []Sample {
+	if step <= 0 {
+		return samples
+	}
+	points := make([]Sample, 0)
+	i := 0
+	for point := from; !point.After(to); point = point.Add(step) {
+		var last *Sample
+		for ; i < len(samples) && !samples[i].Timestamp.After(point); i++ {
+			if samples[i].Timestamp.After(point.Add(-step)) {
+				last = &samples[i]
+			}
+		}
+		if last != nil {
+			points = append(points, Sample{Timestamp: point, Value: last.Value})
+		}
+	}
+	return points
+}
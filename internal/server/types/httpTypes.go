@@ -8,11 +8,50 @@ import (
 	"net/http"
 )
 
+// IdempotencyKeyHeader carries key of write request. Server answers requests
+// with already seen key by remembered response instead of applying them again.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// Metrics is metric of any type, only field of value of its type is set.
 type Metrics struct {
-	Delta *int64   `json:"delta,omitempty"`
-	Value *float64 `json:"value,omitempty"`
-	ID    string   `json:"id"`
-	MType string   `json:"type"`
+	Delta     *int64            `json:"delta,omitempty"`
+	Value     *float64          `json:"value,omitempty"`
+	Histogram *Histogram        `json:"histogram,omitempty"`
+	Summary   *Summary          `json:"summary,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	ID        string            `json:"id"`
+	MType     string            `json:"type"`
+}
+
+// ErrorResponse is body of every error response of server.
+type ErrorResponse struct {
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+	Code    int    `json:"code"`
+}
+
+// BatchResult is response of partial batch update, results are in order of
+// metrics in request.
+type BatchResult struct {
+	Results  []BatchItemResult `json:"results"`
+	Accepted int               `json:"accepted"`
+	Rejected int               `json:"rejected"`
+}
+
+// BatchItemResult tells whether metric of batch is saved, Error is set for
+// rejected one.
+type BatchItemResult struct {
+	Error    *ErrorResponse `json:"error,omitempty"`
+	ID       string         `json:"id"`
+	Index    int            `json:"index"`
+	Accepted bool           `json:"accepted"`
+}
+
+// DeleteResult is response of bulk delete, Deleted lists removed metrics
+// without their values.
+type DeleteResult struct {
+	Deleted []Metrics `json:"deleted"`
+	Count   int       `json:"count"`
 }
 
 type gzipWriter struct {
@@ -0,0 +1,136 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var ErrBadLabels = errors.New("bad labels")
+
+// ValidateLabels checks that label names match [a-zA-Z_][a-zA-Z0-9_]*.
+func ValidateLabels(labels map[string]string) error {
+	for name := range labels {
+		if name == "" {
+			return fmt.Errorf("%w: empty label name", ErrBadLabels)
+		}
+		for i, r := range name {
+			isLetter := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '_'
+			isDigit := r >= '0' && r <= '9'
+			if !isLetter && (!isDigit || i == 0) {
+				return fmt.Errorf("%w: label name %q must match [a-zA-Z_][a-zA-Z0-9_]*", ErrBadLabels, name)
+			}
+		}
+	}
+	return nil
+}
+
+// FormatLabels returns canonical form of labels: pairs name="value" sorted
+// by name and joined by comma. Quotes, backslashes and newlines in values are
+// escaped like in Prometheus exposition.
+func FormatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var builder strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(name)
+		builder.WriteString(`="`)
+		builder.WriteString(escapeLabelValue(labels[name]))
+		builder.WriteByte('"')
+	}
+	return builder.String()
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// ParseLabels is the inverse of FormatLabels.
+func ParseLabels(formatted string) (map[string]string, error) {
+	labels := make(map[string]string)
+	rest := formatted
+	for rest != "" {
+		name, tail, ok := strings.Cut(rest, `="`)
+		if !ok {
+			return nil, fmt.Errorf("%w: can't parse %q", ErrBadLabels, formatted)
+		}
+		var value strings.Builder
+		closed := false
+		i := 0
+		for ; i < len(tail); i++ {
+			switch tail[i] {
+			case '\\':
+				i++
+				if i == len(tail) {
+					return nil, fmt.Errorf("%w: can't parse %q", ErrBadLabels, formatted)
+				}
+				if tail[i] == 'n' {
+					value.WriteByte('\n')
+				} else {
+					value.WriteByte(tail[i])
+				}
+				continue
+			case '"':
+				closed = true
+			default:
+				value.WriteByte(tail[i])
+				continue
+			}
+			break
+		}
+		if !closed {
+			return nil, fmt.Errorf("%w: can't parse %q", ErrBadLabels, formatted)
+		}
+		labels[name] = value.String()
+		rest = strings.TrimPrefix(tail[i+1:], ",")
+	}
+	return labels, nil
+}
+
+// SeriesKey identifies metric with labels in storage maps. Metrics without
+// labels are keyed by bare name, as before labels were introduced.
+func SeriesKey(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+	return name + "{" + FormatLabels(labels) + "}"
+}
+
+// ParseSeriesKey splits series key into metric name and labels.
+func ParseSeriesKey(key string) (string, map[string]string, error) {
+	name, formatted, ok := strings.Cut(key, "{")
+	if !ok {
+		return key, nil, nil
+	}
+	formatted, ok = strings.CutSuffix(formatted, "}")
+	if !ok {
+		return "", nil, fmt.Errorf("%w: series key %q has no closing brace", ErrBadLabels, key)
+	}
+	labels, err := ParseLabels(formatted)
+	if err != nil {
+		return "", nil, err
+	}
+	return name, labels, nil
+}
+
+// MatchLabels reports whether labels contain every pair of filter.
+func MatchLabels(labels, filter map[string]string) bool {
+	for name, value := range filter {
+		actual, ok := labels[name]
+		if !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,35 @@
+package types
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// HashHeader carries HMAC-SHA256 of uncompressed body signed by shared key.
+const HashHeader = "HashSHA256"
+
+// RequestSignData returns data signed for request without body. Signature of
+// empty body is the same for all such requests, so it covers method and
+// request URI with query instead.
+func RequestSignData(method, requestURI string) []byte {
+	return []byte(method + " " + requestURI)
+}
+
+// Sign returns hex encoded HMAC-SHA256 of data.
+func Sign(data []byte, key string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CheckSign reports whether sign is a valid signature of data.
+func CheckSign(data []byte, key, sign string) bool {
+	expected, err := hex.DecodeString(sign)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(data)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
@@ -1,60 +1,154 @@
 package types
 
 import (
-	"context"
-	"database/sql"
 	"fmt"
 	"net/http"
 	"strconv"
+	"sync"
 )
 
 type gauge float64
 
 type counter int64
 
+// MemStorage is safe for concurrent use by handlers and persisting goroutines.
 type MemStorage struct {
-	Gauges   map[string]gauge   `json:"gauges"`
-	Counters map[string]counter `json:"counters"`
+	Gauges     map[string]gauge     `json:"gauges"`
+	Counters   map[string]counter   `json:"counters"`
+	Histograms map[string]Histogram `json:"histograms"`
+	Summaries  map[string]Summary   `json:"summaries"`
+	mu         sync.RWMutex
 }
 
-type SyncInfo struct {
-	DB                *sql.DB
-	FileMetricStorage string
-	SyncFileRecord    bool
+// Snapshot is a consistent copy of MemStorage taken under one lock.
+type Snapshot struct {
+	Gauges     map[string]gauge     `json:"gauges"`
+	Counters   map[string]counter   `json:"counters"`
+	Histograms map[string]Histogram `json:"histograms,omitempty"`
+	Summaries  map[string]Summary   `json:"summaries,omitempty"`
 }
 
-func (s *SyncInfo) CheckBDConnection() error {
-	err := s.DB.PingContext(context.Background())
-	return fmt.Errorf("DB is unreachable: %w", err)
+// Batch is a set of updates which Apply makes at once. Histograms hold
+// observations to add, other maps are like arguments of single updates.
+type Batch struct {
+	Gauges     map[string]float64
+	Counters   map[string]int64
+	Histograms map[string]Histogram
+	Summaries  map[string]Summary
 }
 
 func GetMemStorage() *MemStorage {
 	instance := new(MemStorage)
 	instance.Gauges = map[string]gauge{}
 	instance.Counters = map[string]counter{}
+	instance.Histograms = map[string]Histogram{}
+	instance.Summaries = map[string]Summary{}
 	return instance
 }
 
 func (ms *MemStorage) SetGauge(mName string, mValue float64) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
 	ms.Gauges[mName] = gauge(mValue)
 }
 
-func (ms *MemStorage) SetCounter(mName string, mValue int64) {
+// SetCounter adds mValue to the counter and returns its new total.
+func (ms *MemStorage) SetCounter(mName string, mValue int64) int64 {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
 	ms.Counters[mName] += counter(mValue)
+	return int64(ms.Counters[mName])
 }
 
 func (ms *MemStorage) GetGauge(mName string) (float64, bool) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
 	metric, ok := ms.Gauges[mName]
 	return float64(metric), ok
 }
 
 func (ms *MemStorage) GetCounter(mName string) (int64, bool) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
 	metric, ok := ms.Counters[mName]
 	return int64(metric), ok
 }
 
+// AddHistogram adds observations to the histogram and returns its new
+// state. Histogram with other bounds than saved ones is rejected.
+func (ms *MemStorage) AddHistogram(mName string, histogram Histogram) (Histogram, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	saved, ok := ms.Histograms[mName]
+	if !ok {
+		ms.Histograms[mName] = histogram.Clone()
+		return histogram.Clone(), nil
+	}
+	err := saved.Merge(histogram)
+	if err != nil {
+		return Histogram{}, err
+	}
+	ms.Histograms[mName] = saved
+	return saved.Clone(), nil
+}
+
+func (ms *MemStorage) GetHistogram(mName string) (Histogram, bool) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	metric, ok := ms.Histograms[mName]
+	return metric.Clone(), ok
+}
+
+func (ms *MemStorage) SetSummary(mName string, summary Summary) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.Summaries[mName] = summary.Clone()
+}
+
+func (ms *MemStorage) GetSummary(mName string) (Summary, bool) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	metric, ok := ms.Summaries[mName]
+	return metric.Clone(), ok
+}
+
+// Delete removes metric of type mType and reports whether it existed.
+func (ms *MemStorage) Delete(mType, mName string) bool {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	var ok bool
+	switch mType {
+	case "gauge":
+		_, ok = ms.Gauges[mName]
+		delete(ms.Gauges, mName)
+	case "counter":
+		_, ok = ms.Counters[mName]
+		delete(ms.Counters, mName)
+	case "histogram":
+		_, ok = ms.Histograms[mName]
+		delete(ms.Histograms, mName)
+	case "summary":
+		_, ok = ms.Summaries[mName]
+		delete(ms.Summaries, mName)
+	}
+	return ok
+}
+
+// ResetCounter sets counter to zero and reports whether it existed.
+func (ms *MemStorage) ResetCounter(mName string) bool {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, ok := ms.Counters[mName]; !ok {
+		return false
+	}
+	ms.Counters[mName] = 0
+	return true
+}
+
 func (ms *MemStorage) GetGauges() map[string]string {
-	gauges := make(map[string]string)
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	gauges := make(map[string]string, len(ms.Gauges))
 	for k, v := range ms.Gauges {
 		gauges[k] = strconv.FormatFloat(float64(v), 'f', -1, 64)
 	}
@@ -62,7 +156,9 @@ func (ms *MemStorage) GetGauges() map[string]string {
 }
 
 func (ms *MemStorage) GetCounters() map[string]string {
-	counters := make(map[string]string)
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	counters := make(map[string]string, len(ms.Counters))
 	for k, v := range ms.Counters {
 		counters[k] = strconv.FormatInt(int64(v), 10)
 	}
@@ -70,17 +166,129 @@ func (ms *MemStorage) GetCounters() map[string]string {
 }
 
 func (ms *MemStorage) SetGauges(data map[string]float64) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
 	for k, v := range data {
 		ms.Gauges[k] = gauge(v)
 	}
 }
 
 func (ms *MemStorage) SetCounters(data map[string]float64) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
 	for k, v := range data {
 		ms.Counters[k] += counter(v)
 	}
 }
 
+// Apply makes all updates of batch under one lock, so readers see either
+// none or all of them. Nothing is applied if bounds of any histogram differ
+// from saved ones. It returns new totals of updated counters.
+func (ms *MemStorage) Apply(batch Batch) (map[string]int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for k, v := range batch.Histograms {
+		saved, ok := ms.Histograms[k]
+		if !ok {
+			continue
+		}
+		saved = saved.Clone()
+		err := saved.Merge(v)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", err, k)
+		}
+	}
+
+	for k, v := range batch.Gauges {
+		ms.Gauges[k] = gauge(v)
+	}
+	totals := make(map[string]int64, len(batch.Counters))
+	for k, v := range batch.Counters {
+		ms.Counters[k] += counter(v)
+		totals[k] = int64(ms.Counters[k])
+	}
+	for k, v := range batch.Histograms {
+		saved, ok := ms.Histograms[k]
+		if !ok {
+			ms.Histograms[k] = v.Clone()
+			continue
+		}
+		// Bounds are already checked above.
+		_ = saved.Merge(v)
+		ms.Histograms[k] = saved
+	}
+	for k, v := range batch.Summaries {
+		ms.Summaries[k] = v.Clone()
+	}
+	return totals, nil
+}
+
+// Snapshot copies all metrics, so the copy can be marshalled or iterated
+// without holding the lock.
+func (ms *MemStorage) Snapshot() Snapshot {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	snapshot := Snapshot{
+		Gauges:     make(map[string]gauge, len(ms.Gauges)),
+		Counters:   make(map[string]counter, len(ms.Counters)),
+		Histograms: make(map[string]Histogram, len(ms.Histograms)),
+		Summaries:  make(map[string]Summary, len(ms.Summaries)),
+	}
+	for k, v := range ms.Gauges {
+		snapshot.Gauges[k] = v
+	}
+	for k, v := range ms.Counters {
+		snapshot.Counters[k] = v
+	}
+	for k, v := range ms.Histograms {
+		snapshot.Histograms[k] = v.Clone()
+	}
+	for k, v := range ms.Summaries {
+		snapshot.Summaries[k] = v.Clone()
+	}
+	return snapshot
+}
+
+// Restore replaces all metrics by content of snapshot.
+func (ms *MemStorage) Restore(snapshot Snapshot) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.Gauges = make(map[string]gauge, len(snapshot.Gauges))
+	ms.Counters = make(map[string]counter, len(snapshot.Counters))
+	ms.Histograms = make(map[string]Histogram, len(snapshot.Histograms))
+	ms.Summaries = make(map[string]Summary, len(snapshot.Summaries))
+	for k, v := range snapshot.Gauges {
+		ms.Gauges[k] = v
+	}
+	for k, v := range snapshot.Counters {
+		ms.Counters[k] = v
+	}
+	for k, v := range snapshot.Histograms {
+		ms.Histograms[k] = v.Clone()
+	}
+	for k, v := range snapshot.Summaries {
+		ms.Summaries[k] = v.Clone()
+	}
+}
+
+// GaugeValues returns gauges of snapshot as plain float64 values.
+func (s Snapshot) GaugeValues() map[string]float64 {
+	values := make(map[string]float64, len(s.Gauges))
+	for k, v := range s.Gauges {
+		values[k] = float64(v)
+	}
+	return values
+}
+
+// CounterValues returns counters of snapshot as plain int64 values.
+func (s Snapshot) CounterValues() map[string]int64 {
+	values := make(map[string]int64, len(s.Counters))
+	for k, v := range s.Counters {
+		values[k] = int64(v)
+	}
+	return values
+}
+
 type (
 	ResponseData struct {
 		Status int
@@ -1,9 +1,20 @@
 package types
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSetGauge(t *testing.T) {
@@ -155,3 +166,325 @@ func TestGetCounter(t *testing.T) {
 		})
 	}
 }
+
+func TestHistory(t *testing.T) {
+	start := time.Unix(1000, 0)
+	history := NewHistory(3)
+	for i := range 5 {
+		history.Add("gauge", "Alloc", Sample{Timestamp: start.Add(time.Duration(i) * time.Second), Value: float64(i)})
+	}
+	history.Add("counter", "Alloc", Sample{Timestamp: start, Value: 100})
+
+	tests := []struct {
+		name  string
+		mType string
+		from  time.Time
+		to    time.Time
+		want  []float64
+	}{
+		{
+			name:  "Oldest samples are evicted",
+			mType: "gauge",
+			from:  start,
+			to:    start.Add(time.Minute),
+			want:  []float64{2, 3, 4},
+		},
+		{
+			name:  "Samples are filtered by range",
+			mType: "gauge",
+			from:  start.Add(3 * time.Second),
+			to:    start.Add(3 * time.Second),
+			want:  []float64{3},
+		},
+		{
+			name:  "Metrics of different types are separated",
+			mType: "counter",
+			from:  start,
+			to:    start.Add(time.Minute),
+			want:  []float64{100},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			samples := history.Range(test.mType, "Alloc", test.from, test.to)
+			values := make([]float64, 0, len(samples))
+			for _, sample := range samples {
+				values = append(values, sample.Value)
+			}
+			assert.Equal(t, test.want, values)
+		})
+	}
+}
+
+func TestDownsample(t *testing.T) {
+	start := time.Unix(1000, 0)
+	samples := []Sample{
+		{Timestamp: start, Value: 1},
+		{Timestamp: start.Add(4 * time.Second), Value: 2},
+		{Timestamp: start.Add(9 * time.Second), Value: 3},
+		{Timestamp: start.Add(25 * time.Second), Value: 4},
+	}
+	points := Downsample(samples, start, start.Add(30*time.Second), 10*time.Second)
+	assert.Equal(t, []Sample{
+		{Timestamp: start, Value: 1},
+		{Timestamp: start.Add(10 * time.Second), Value: 3},
+		{Timestamp: start.Add(30 * time.Second), Value: 4},
+	}, points)
+}
+
+func TestAggregate(t *testing.T) {
+	start := time.Unix(1000, 0)
+	values := []float64{4, 1, 3, 2, 10}
+	samples := make([]Sample, 0, len(values))
+	for i, value := range values {
+		samples = append(samples, Sample{Timestamp: start.Add(time.Duration(i) * time.Second), Value: value})
+	}
+	// Counter is reset between 3rd and 4th samples.
+	counterSamples := []Sample{
+		{Timestamp: start, Value: 10},
+		{Timestamp: start.Add(2 * time.Second), Value: 14},
+		{Timestamp: start.Add(4 * time.Second), Value: 2},
+	}
+
+	tests := []struct {
+		name     string
+		fn       string
+		samples  []Sample
+		quantile float64
+		want     float64
+		ok       bool
+	}{
+		{name: "Min", fn: AggMin, samples: samples, want: 1, ok: true},
+		{name: "Max", fn: AggMax, samples: samples, want: 10, ok: true},
+		{name: "Avg", fn: AggAvg, samples: samples, want: 4, ok: true},
+		{name: "Sum", fn: AggSum, samples: samples, want: 20, ok: true},
+		{name: "Count", fn: AggCount, samples: samples, want: 5, ok: true},
+		{name: "Count of empty window", fn: AggCount, samples: nil, want: 0, ok: true},
+		{name: "Last", fn: AggLast, samples: samples, want: 10, ok: true},
+		{name: "Median", fn: AggQuantile, samples: samples, quantile: 0.5, want: 3, ok: true},
+		{name: "Interpolated quantile", fn: AggQuantile, samples: samples, quantile: 0.875, want: 7, ok: true},
+		{name: "Rate with counter reset", fn: AggRate, samples: counterSamples, want: 1.5, ok: true},
+		{name: "Rate of single sample", fn: AggRate, samples: counterSamples[:1], ok: false},
+		{name: "Avg of empty window", fn: AggAvg, samples: nil, ok: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, ok := Aggregate(test.samples, test.fn, test.quantile)
+			assert.Equal(t, test.ok, ok)
+			assert.InDelta(t, test.want, value, 1e-9)
+		})
+	}
+}
+
+func TestSeriesKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		metric string
+		want   string
+	}{
+		{
+			name:   "Metric without labels",
+			metric: "Alloc",
+			want:   "Alloc",
+		},
+		{
+			name:   "Labels are sorted",
+			metric: "Alloc",
+			labels: map[string]string{"service": "api", "host": "web1"},
+			want:   `Alloc{host="web1",service="api"}`,
+		},
+		{
+			name:   "Label values are escaped",
+			metric: "Alloc",
+			labels: map[string]string{"env": "a\"b\\c\nd,e}"},
+			want:   `Alloc{env="a\"b\\c\nd,e}"}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key := SeriesKey(test.metric, test.labels)
+			assert.Equal(t, test.want, key)
+
+			name, labels, err := ParseSeriesKey(key)
+			assert.NoError(t, err)
+			assert.Equal(t, test.metric, name)
+			if len(test.labels) == 0 {
+				assert.Empty(t, labels)
+			} else {
+				assert.Equal(t, test.labels, labels)
+			}
+		})
+	}
+}
+
+func TestValidateLabels(t *testing.T) {
+	assert.NoError(t, ValidateLabels(map[string]string{"host": "web-1", "_env2": ""}))
+	assert.ErrorIs(t, ValidateLabels(map[string]string{"2host": "web"}), ErrBadLabels)
+	assert.ErrorIs(t, ValidateLabels(map[string]string{"host-name": "web"}), ErrBadLabels)
+	assert.ErrorIs(t, ValidateLabels(map[string]string{"": "web"}), ErrBadLabels)
+}
+
+func TestSign(t *testing.T) {
+	data := []byte(`{"id":"Alloc","type":"gauge","value":1}`)
+	sign := Sign(data, "secret")
+	assert.True(t, CheckSign(data, "secret", sign))
+	assert.False(t, CheckSign(data, "other", sign))
+	assert.False(t, CheckSign([]byte(`{}`), "secret", sign))
+	assert.False(t, CheckSign(data, "secret", ""))
+	assert.False(t, CheckSign(data, "secret", "not hex"))
+}
+
+func TestEncrypt(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	publicPath := filepath.Join(dir, "public.pem")
+	publicDER, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(publicPath,
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0o600))
+	privatePath := filepath.Join(dir, "private.pem")
+	require.NoError(t, os.WriteFile(privatePath,
+		pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}), 0o600))
+
+	publicKey, err := LoadPublicKey(publicPath)
+	require.NoError(t, err)
+	loadedKey, err := LoadPrivateKey(privatePath)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		scheme string
+		data   []byte
+	}{
+		{
+			name:   "Small body fits in RSA block",
+			scheme: EncryptionRSA,
+			data:   []byte(`{"id":"PollCount","type":"counter","delta":5}`),
+		},
+		{
+			name:   "Large body uses hybrid scheme",
+			scheme: EncryptionHybrid,
+			data:   bytes.Repeat([]byte("metric"), 10000),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ciphertext, scheme, err := Encrypt(publicKey, test.data)
+			require.NoError(t, err)
+			assert.Equal(t, test.scheme, scheme)
+			assert.NotEqual(t, test.data, ciphertext)
+
+			plain, err := Decrypt(loadedKey, scheme, ciphertext)
+			require.NoError(t, err)
+			assert.Equal(t, test.data, plain)
+
+			ciphertext[len(ciphertext)-1] ^= 1
+			_, err = Decrypt(loadedKey, scheme, ciphertext)
+			assert.Error(t, err)
+		})
+	}
+	_, err = Decrypt(loadedKey, "rot13", nil)
+	assert.ErrorIs(t, err, ErrUnknownEncryption)
+	_, err = Decrypt(loadedKey, EncryptionHybrid, []byte("short"))
+	assert.ErrorIs(t, err, ErrBadCiphertext)
+}
+
+func TestMetricsValidate(t *testing.T) {
+	value, delta, inf := 1.5, int64(3), math.Inf(-1)
+	tests := []struct {
+		name   string
+		metric Metrics
+		field  string
+	}{
+		{name: "Valid gauge", metric: Metrics{ID: "Heap.Max", MType: "gauge", Value: &value}},
+		{name: "Valid counter", metric: Metrics{ID: "PollCount", MType: "counter", Delta: &delta}},
+		{name: "Empty name", metric: Metrics{MType: "gauge", Value: &value}, field: "id"},
+		{name: "Name with space", metric: Metrics{ID: "Heap Max", MType: "gauge", Value: &value}, field: "id"},
+		{name: "Missing type", metric: Metrics{ID: "Alloc", Value: &value}, field: "type"},
+		{name: "Gauge without value", metric: Metrics{ID: "Alloc", MType: "gauge", Delta: &delta}, field: "value"},
+		{name: "Infinite gauge", metric: Metrics{ID: "Alloc", MType: "gauge", Value: &inf}, field: "value"},
+		{name: "Counter without delta", metric: Metrics{ID: "Poll", MType: "counter", Value: &value}, field: "delta"},
+		{
+			name:   "Bad label",
+			metric: Metrics{ID: "Alloc", MType: "gauge", Value: &value, Labels: map[string]string{"1host": "web"}},
+			field:  "labels",
+		},
+		{
+			name: "Valid histogram",
+			metric: Metrics{ID: "Latency", MType: "histogram",
+				Histogram: &Histogram{Bounds: []float64{0.1, 1}, Counts: []int64{1, 2, 3}, Sum: 4}},
+		},
+		{name: "Histogram without value", metric: Metrics{ID: "Latency", MType: "histogram"}, field: "histogram"},
+		{
+			name: "Decreasing bounds",
+			metric: Metrics{ID: "Latency", MType: "histogram",
+				Histogram: &Histogram{Bounds: []float64{1, 0.1}, Counts: []int64{1, 2, 3}}},
+			field: "histogram.bounds",
+		},
+		{
+			name: "No count above bounds",
+			metric: Metrics{ID: "Latency", MType: "histogram",
+				Histogram: &Histogram{Bounds: []float64{0.1, 1}, Counts: []int64{1, 2}}},
+			field: "histogram.counts",
+		},
+		{
+			name: "Valid summary",
+			metric: Metrics{ID: "Duration", MType: "summary",
+				Summary: &Summary{Quantiles: []Quantile{{Quantile: 0.5, Value: 1}, {Quantile: 0.99, Value: 3}}, Count: 2}},
+		},
+		{
+			name: "Quantile above one",
+			metric: Metrics{ID: "Duration", MType: "summary",
+				Summary: &Summary{Quantiles: []Quantile{{Quantile: 1.5, Value: 1}}}},
+			field: "summary.quantiles",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.metric.Validate()
+			if test.field == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var validationErr *ValidationError
+			require.ErrorAs(t, err, &validationErr)
+			assert.ErrorIs(t, err, ErrInvalidMetric)
+			assert.Equal(t, test.field, validationErr.Field)
+		})
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	histogram := Histogram{Bounds: []float64{0.1, 1}, Counts: []int64{1, 2, 3}, Sum: 4}
+	clone := histogram.Clone()
+	require.NoError(t, histogram.Merge(Histogram{Bounds: []float64{0.1, 1}, Counts: []int64{1, 0, 1}, Sum: 2}))
+	assert.Equal(t, Histogram{Bounds: []float64{0.1, 1}, Counts: []int64{2, 2, 4}, Sum: 6}, histogram)
+	assert.Equal(t, int64(8), histogram.Count())
+	assert.Equal(t, []int64{1, 2, 3}, clone.Counts, "clone doesn't share counts")
+
+	err := histogram.Merge(Histogram{Bounds: []float64{0.5, 1}, Counts: []int64{1, 1, 1}})
+	assert.ErrorIs(t, err, ErrBoundsMismatch)
+	assert.Equal(t, []int64{2, 2, 4}, histogram.Counts, "histogram isn't changed by rejected merge")
+}
+
+func TestAgentRegistryIsCapped(t *testing.T) {
+	registry := NewAgentRegistry()
+	now := time.Now()
+	for i := range MaxAgents + 1 {
+		registry.Seen(fmt.Sprintf("host%d", i), "", now.Add(time.Duration(i)*time.Second))
+	}
+	registry.Seen("host1", "", now.Add(time.Hour))
+
+	agents := registry.List(now, time.Minute)
+	require.Len(t, agents, MaxAgents)
+	ids := make(map[string]bool, len(agents))
+	for _, agent := range agents {
+		ids[agent.ID] = true
+	}
+	assert.False(t, ids["host0"], "agent reported earliest is forgotten")
+	assert.True(t, ids["host1"])
+	assert.True(t, ids[fmt.Sprintf("host%d", MaxAgents)])
+}
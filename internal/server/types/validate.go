@@ -0,0 +1,113 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// MaxNameLength matches varchar(100) column of metric name in database.
+const MaxNameLength = 100
+
+var ErrInvalidMetric = errors.New("invalid metric")
+
+// ValidationError describes which field of metric is invalid.
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (ve *ValidationError) Error() string {
+	return fmt.Sprintf("%v: %s: %s", ErrInvalidMetric, ve.Field, ve.Message)
+}
+
+func (ve *ValidationError) Unwrap() error {
+	return ErrInvalidMetric
+}
+
+// ValidateName checks that metric name is not empty, fits in MaxNameLength
+// and matches [a-zA-Z0-9_.:-]+.
+func ValidateName(name string) error {
+	if name == "" {
+		return &ValidationError{Field: "id", Message: "is required"}
+	}
+	if len(name) > MaxNameLength {
+		return &ValidationError{Field: "id", Message: fmt.Sprintf("must be at most %d bytes", MaxNameLength)}
+	}
+	for _, r := range name {
+		isLetter := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+		isDigit := r >= '0' && r <= '9'
+		if !isLetter && !isDigit && r != '_' && r != '.' && r != ':' && r != '-' {
+			return &ValidationError{Field: "id", Message: fmt.Sprintf("%q must match [a-zA-Z0-9_.:-]+", name)}
+		}
+	}
+	return nil
+}
+
+// ValidateType checks that metric type is gauge, counter, histogram or
+// summary.
+func ValidateType(mType string) error {
+	switch mType {
+	case "":
+		return &ValidationError{Field: "type", Message: "is required"}
+	case "gauge", "counter", "histogram", "summary":
+		return nil
+	default:
+		return &ValidationError{Field: "type", Message: "must be gauge, counter, histogram or summary, got " + mType}
+	}
+}
+
+// ValidateValue checks that gauge value is finite.
+func ValidateValue(value float64) error {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return &ValidationError{Field: "value", Message: "must be finite number"}
+	}
+	return nil
+}
+
+// ValidateKey checks fields which identify metric: name, type and labels.
+func (m *Metrics) ValidateKey() error {
+	err := ValidateName(m.ID)
+	if err != nil {
+		return err
+	}
+	err = ValidateType(m.MType)
+	if err != nil {
+		return err
+	}
+	err = ValidateLabels(m.Labels)
+	if err != nil {
+		return &ValidationError{Field: "labels", Message: err.Error()}
+	}
+	return nil
+}
+
+// Validate checks metric sent for update: its key and value required by its
+// type.
+func (m *Metrics) Validate() error {
+	err := m.ValidateKey()
+	if err != nil {
+		return err
+	}
+	switch m.MType {
+	case "counter":
+		if m.Delta == nil {
+			return &ValidationError{Field: "delta", Message: "is required for counter"}
+		}
+		return nil
+	case "histogram":
+		if m.Histogram == nil {
+			return &ValidationError{Field: "histogram", Message: "is required for histogram"}
+		}
+		return m.Histogram.Validate()
+	case "summary":
+		if m.Summary == nil {
+			return &ValidationError{Field: "summary", Message: "is required for summary"}
+		}
+		return m.Summary.Validate()
+	}
+	if m.Value == nil {
+		return &ValidationError{Field: "value", Message: "is required for gauge"}
+	}
+	return ValidateValue(*m.Value)
+}